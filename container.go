@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"logs-ecstask/ecstrace"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var taskHeaderStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("39")).
+	Bold(true)
+
+// コンテナの状態を表形式で描画
+func renderContainerTable(summaries []ecstrace.ContainerSummary, plain bool) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tESSENTIAL\tSTATUS\tEXIT\tHEALTH\tIMAGE DIGEST\tREASON")
+	for _, s := range summaries {
+		exit := "-"
+		if s.ExitCode != nil {
+			exit = fmt.Sprint(*s.ExitCode)
+		}
+		name := s.Name
+		if s.CausedStop {
+			name = "! " + name
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%s\t%s\n",
+			name, s.Essential, dashIfEmpty(s.LastStatus), exit,
+			dashIfEmpty(s.Health), dashIfEmpty(shortDigest(s.ImageDigest)), dashIfEmpty(s.Reason))
+	}
+	w.Flush()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if !plain {
+		// 列を揃えた後に行単位で色を付ける
+		lines[0] = taskHeaderStyle.Render(lines[0])
+		for i, s := range summaries {
+			if s.CausedStop {
+				lines[i+1] = errorStyle.Render(lines[i+1])
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// タスク ARN・ステータス・コンテナ一覧をまとめたヘッダー
+func renderTaskHeader(taskArn, lastStatus string, summaries []ecstrace.ContainerSummary, plain bool) string {
+	var b strings.Builder
+	if plain {
+		fmt.Fprintf(&b, "Task ARN: %s\n", taskArn)
+		fmt.Fprintf(&b, "Last Status: %s\n", lastStatus)
+	} else {
+		fmt.Fprintf(&b, "%s %s\n", taskHeaderStyle.Render("Task ARN:"), taskMessageStyle.Render(taskArn))
+		fmt.Fprintf(&b, "%s %s\n", taskHeaderStyle.Render("Last Status:"), taskMessageStyle.Render(lastStatus))
+	}
+	if len(summaries) > 0 {
+		b.WriteString("\n")
+		b.WriteString(renderContainerTable(summaries, plain))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// "sha256:" に続くダイジェストを先頭12文字に短縮
+func shortDigest(digest string) string {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok || len(hex) <= 12 {
+		return digest
+	}
+	return algo + ":" + hex[:12]
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"logs-ecstask/ecstrace"
+
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// デプロイの状態 (ロールアウト・件数・サーキットブレーカー)
+func renderDeploymentHeader(d ecstrace.DeploymentSummary, plain bool) string {
+	label := func(s string) string {
+		if plain {
+			return s
+		}
+		return taskHeaderStyle.Render(s)
+	}
+
+	rollout := dashIfEmpty(d.RolloutState)
+	if d.RolloutStateReason != "" {
+		rollout += " (" + d.RolloutStateReason + ")"
+	}
+	if !plain && d.RolloutState == string(ecsTypes.DeploymentRolloutStateFailed) {
+		rollout = errorStyle.Render(rollout)
+	}
+
+	breaker := "disabled"
+	if d.CircuitBreaker {
+		breaker = "enabled"
+		if d.CircuitBreakerRollback {
+			breaker += ", rollback"
+		}
+	}
+
+	failed := fmt.Sprint(d.FailedTasks)
+	if !plain && d.FailedTasks > 0 {
+		failed = errorStyle.Render(failed)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s (%s, %s)\n", label("Deployment:"), d.ID, dashIfEmpty(d.Status), d.TaskDefinition)
+	fmt.Fprintf(&b, "%s %s\n", label("Rollout:"), rollout)
+	fmt.Fprintf(&b, "%s desired %d, running %d, pending %d, failed %s\n",
+		label("Tasks:"), d.DesiredCount, d.RunningCount, d.PendingCount, failed)
+	fmt.Fprintf(&b, "%s %s\n", label("Circuit breaker:"), breaker)
+	return b.String()
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"logs-ecstask/ecstrace"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// 1カテゴリあたりに表示する根拠イベントの最大数
+const maxEvidence = 5
+
+// 停止の直前とみなす期間
+// この間のサービスイベントは、タスク ID を含まなくてもこのタスクの根拠にする
+const stopEvidenceWindow = 5 * time.Minute
+
+// 停止原因の判定結果
+type diagnosis struct {
+	Category    string
+	Explanation string
+	// 判定の根拠になったイベント (古い順)
+	Evidence []ecstrace.TimelineEvent
+}
+
+// -output json での判定結果
+type jsonDiagnosis struct {
+	Category    string      `json:"category"`
+	Explanation string      `json:"explanation"`
+	Evidence    []jsonEvent `json:"evidence"`
+}
+
+// -output json での出力全体
+type diagnoseDocument struct {
+	taskMetadata
+	StopCode      string          `json:"stop_code,omitempty"`
+	StoppedReason string          `json:"stopped_reason,omitempty"`
+	Diagnoses     []jsonDiagnosis `json:"diagnoses"`
+}
+
+// 判定に使う情報
+type diagnoseInput struct {
+	task       ecsTypes.Task
+	containers []ecstrace.ContainerSummary
+	// 時刻順に並んだサービスイベント・TASK イベント・コンテナログ
+	events []ecstrace.TimelineEvent
+}
+
+// 停止原因のルール
+type diagnoseRule struct {
+	category    string
+	explanation string
+	match       func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool)
+}
+
+var (
+	oomPattern         = regexp.MustCompile(`(?i)OutOfMemory|out of memory|OOMKilled|oom-kill`)
+	imagePullPattern   = regexp.MustCompile(`(?i)CannotPullContainerError|pull image|pulling image|manifest .* not found|ImagePull`)
+	healthCheckPattern = regexp.MustCompile(`(?i)failed (container|ELB) health checks?|is unhealthy in \(?target-group|health check.*fail`)
+	deregisterPattern  = regexp.MustCompile(`(?i)deregistered \d+ targets?|draining connections|deregistration`)
+	secretsPattern     = regexp.MustCompile(`(?i)secretsmanager|ssm:GetParameters|unable to retrieve secret|fetch secret|retrieve secrets|ResourceInitializationError.*(secret|ssm|parameter)`)
+	spotPattern        = regexp.MustCompile(`(?i)spot interruption|spot instance`)
+)
+
+// 判定ルール (上から順に評価し、該当するものをすべて返す)
+var diagnoseRules = []diagnoseRule{
+	{
+		category:    "OOM",
+		explanation: "A container was killed because it ran out of memory (exit code 137). Raise the container/task memory or reduce the application's memory usage.",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			hit := false
+			for _, c := range in.containers {
+				if (c.ExitCode != nil && *c.ExitCode == 137) || oomPattern.MatchString(c.Reason) {
+					hit = true
+				}
+			}
+			logs := in.find(oomPattern)
+			return append(in.stopEvents(), logs...), hit || len(logs) > 0
+		},
+	},
+	{
+		category:    "Image pull failure",
+		explanation: "The container image could not be pulled. Check the image name/tag, that it exists in the registry, the execution role's ECR permissions and the network path to the registry (NAT / VPC endpoints).",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			hit := in.task.StopCode == ecsTypes.TaskStopCodeTaskFailedToStart &&
+				imagePullPattern.MatchString(aws.ToString(in.task.StoppedReason))
+			for _, c := range in.containers {
+				if imagePullPattern.MatchString(c.Reason) {
+					hit = true
+				}
+			}
+			return append(in.stopEvents(), in.find(imagePullPattern)...), hit
+		},
+	},
+	{
+		category:    "Failed health check",
+		explanation: "The task was replaced because a container or load balancer health check failed. Check the health check command/path, the grace period and the application's startup time.",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			evidence := in.find(healthCheckPattern)
+			hit := healthCheckPattern.MatchString(aws.ToString(in.task.StoppedReason))
+			for _, c := range in.containers {
+				if c.Health == string(ecsTypes.HealthStatusUnhealthy) {
+					hit = true
+				}
+			}
+			return append(in.stopEvents(), evidence...), hit || len(evidence) > 0
+		},
+	},
+	{
+		category:    "ELB target deregistration",
+		explanation: "The task was deregistered from its load balancer target group (deployment, scale-in or failed target health) before it stopped.",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			// 登録解除のイベントはタスクを特定しないため、停止の直前のものだけを見る
+			var evidence []ecstrace.TimelineEvent
+			for _, e := range in.find(deregisterPattern) {
+				if in.nearStop(e.Timestamp) {
+					evidence = append(evidence, e)
+				}
+			}
+			return evidence, len(evidence) > 0
+		},
+	},
+	{
+		category:    "Secrets / SSM resolution error",
+		explanation: "ECS could not resolve a secret or SSM parameter for the container. Check the secret ARN / parameter name and that the task execution role can read it (secretsmanager:GetSecretValue, ssm:GetParameters, kms:Decrypt).",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			hit := secretsPattern.MatchString(aws.ToString(in.task.StoppedReason))
+			for _, c := range in.containers {
+				if secretsPattern.MatchString(c.Reason) {
+					hit = true
+				}
+			}
+			return append(in.stopEvents(), in.find(secretsPattern)...), hit
+		},
+	},
+	{
+		category:    "Essential container exited",
+		explanation: "An essential container exited, so ECS stopped the whole task. The last log lines of that container usually show why.",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			if in.task.StopCode != ecsTypes.TaskStopCodeEssentialContainerExited {
+				return nil, false
+			}
+			evidence := in.stopEvents()
+			for _, c := range in.containers {
+				if c.CausedStop {
+					evidence = append(evidence, in.lastLogs(c.Name, maxEvidence)...)
+				}
+			}
+			return evidence, true
+		},
+	},
+	{
+		category:    "Spot interruption",
+		explanation: "The task ran on Fargate Spot / Spot capacity and was interrupted when AWS reclaimed the capacity. Use a capacity provider strategy with on-demand base capacity for critical workloads.",
+		match: func(in diagnoseInput) ([]ecstrace.TimelineEvent, bool) {
+			hit := in.task.StopCode == ecsTypes.TaskStopCodeSpotInterruption ||
+				spotPattern.MatchString(aws.ToString(in.task.StoppedReason))
+			return append(in.stopEvents(), in.find(spotPattern)...), hit
+		},
+	},
+}
+
+// 停止したタスクの原因を判定する
+// どのルールにも該当しなければ StoppedReason をそのまま返す
+func diagnoseTask(in diagnoseInput) []diagnosis {
+	in.events = ecstrace.SortedAscending(in.events)
+
+	var results []diagnosis
+	for _, r := range diagnoseRules {
+		evidence, ok := r.match(in)
+		if !ok {
+			continue
+		}
+		results = append(results, diagnosis{
+			Category:    r.category,
+			Explanation: r.explanation,
+			Evidence:    limitEvidence(evidence),
+		})
+	}
+
+	if len(results) == 0 {
+		results = append(results, diagnosis{
+			Category:    "Unknown",
+			Explanation: "No known failure pattern matched. Stopped reason: " + dashIfEmpty(aws.ToString(in.task.StoppedReason)),
+			Evidence:    limitEvidence(in.stopEvents()),
+		})
+	}
+	return results
+}
+
+// message が正規表現に一致するイベント
+// サービスイベントは他のタスクのものも含むため、タスク ID を含むか停止の直前のものに限る
+func (in diagnoseInput) find(re *regexp.Regexp) []ecstrace.TimelineEvent {
+	var found []ecstrace.TimelineEvent
+	for _, e := range in.events {
+		if !re.MatchString(e.Message) {
+			continue
+		}
+		if e.Source == ecstrace.ServiceSource && !in.mentionsTask(e.Message) && !in.nearStop(e.Timestamp) {
+			continue
+		}
+		found = append(found, e)
+	}
+	return found
+}
+
+// メッセージがこのタスクの ID を含むか
+func (in diagnoseInput) mentionsTask(msg string) bool {
+	taskID := ecstrace.ArnToName(aws.ToString(in.task.TaskArn))
+	return taskID != "" && strings.Contains(msg, taskID)
+}
+
+// 停止処理の開始 (StoppingAt、なければ StoppedAt) の stopEvidenceWindow 前から StoppedAt までか
+func (in diagnoseInput) nearStop(ts time.Time) bool {
+	start, end := in.task.StoppingAt, in.task.StoppedAt
+	if start == nil {
+		start = end
+	}
+	if end == nil {
+		end = start
+	}
+	if start == nil {
+		return false
+	}
+	return !ts.Before(start.Add(-stopEvidenceWindow)) && !ts.After(*end)
+}
+
+// TASK ソースの停止イベント
+func (in diagnoseInput) stopEvents() []ecstrace.TimelineEvent {
+	var found []ecstrace.TimelineEvent
+	for _, e := range in.events {
+		if e.Source == ecstrace.TaskSource && strings.HasPrefix(e.Message, "Task stopped") {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// 指定したコンテナの最後の n 行
+func (in diagnoseInput) lastLogs(source string, n int) []ecstrace.TimelineEvent {
+	var found []ecstrace.TimelineEvent
+	for _, e := range in.events {
+		if e.Source == source {
+			found = append(found, e)
+		}
+	}
+	if len(found) > n {
+		found = found[len(found)-n:]
+	}
+	return found
+}
+
+// 重複を除いて古い順に並べ、新しいものから maxEvidence 件に絞る
+func limitEvidence(events []ecstrace.TimelineEvent) []ecstrace.TimelineEvent {
+	seen := make(map[string]bool)
+	var unique []ecstrace.TimelineEvent
+	for _, e := range events {
+		if k := e.Key(); !seen[k] {
+			seen[k] = true
+			unique = append(unique, e)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return unique[i].Timestamp.Before(unique[j].Timestamp)
+	})
+	if len(unique) > maxEvidence {
+		unique = unique[len(unique)-maxEvidence:]
+	}
+	return unique
+}
+
+var diagnoseCategoryStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#ff5f5f")).
+	Bold(true)
+
+// 判定結果を出力
+func printDiagnoses(w io.Writer, diags []diagnosis, plain bool) {
+	for i, d := range diags {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		title := fmt.Sprintf("[%s]", d.Category)
+		if !plain {
+			title = diagnoseCategoryStyle.Render(title)
+		}
+		fmt.Fprintln(w, title)
+		fmt.Fprintf(w, "  %s\n", d.Explanation)
+		if len(d.Evidence) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, "  Evidence:")
+		for _, e := range d.Evidence {
+			fmt.Fprintf(w, "    %s\n", renderPlainEvent(e))
+		}
+	}
+}
+
+// 判定結果を JSON で出力
+func writeDiagnosesJSON(w io.Writer, task ecsTypes.Task, meta taskMetadata, diags []diagnosis) error {
+	doc := diagnoseDocument{
+		taskMetadata:  meta,
+		StopCode:      string(task.StopCode),
+		StoppedReason: aws.ToString(task.StoppedReason),
+		Diagnoses:     make([]jsonDiagnosis, 0, len(diags)),
+	}
+	for _, d := range diags {
+		jd := jsonDiagnosis{Category: d.Category, Explanation: d.Explanation, Evidence: []jsonEvent{}}
+		for _, e := range d.Evidence {
+			jd.Evidence = append(jd.Evidence, toJSONEvent(e))
+		}
+		doc.Diagnoses = append(doc.Diagnoses, jd)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
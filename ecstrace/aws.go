@@ -0,0 +1,236 @@
+package ecstrace
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// DescribeTasks で一度に指定できるタスク数の上限
+const describeTasksBatchSize = 100
+
+// クラスター名の一覧を名前順に取得
+func ListClusters(ctx context.Context, ecsClient ECSAPI) ([]string, error) {
+	var clusters []string
+	var nextToken *string
+
+	for {
+		out, err := ecsClient.ListClusters(ctx, &ecs.ListClustersInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range out.ClusterArns {
+			cName := ArnToName(arn)
+			clusters = append(clusters, cName)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no clusters found")
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// クラスターの実行中・起動中・停止済みのタスク ARN を取得
+func ListTaskArns(ctx context.Context, ecsClient ECSAPI, cluster string) ([]string, error) {
+	statuses := []ecsTypes.DesiredStatus{
+		ecsTypes.DesiredStatusRunning,
+		ecsTypes.DesiredStatusPending,
+		ecsTypes.DesiredStatusStopped,
+	}
+
+	var taskArns []string
+	for _, st := range statuses {
+		var nextToken *string
+		for {
+			tlist, err := ecsClient.ListTasks(ctx, &ecs.ListTasksInput{
+				Cluster:       &cluster,
+				DesiredStatus: st,
+				NextToken:     nextToken,
+			})
+			if err != nil {
+				return nil, err
+			}
+			taskArns = append(taskArns, tlist.TaskArns...)
+			if tlist.NextToken == nil {
+				break
+			}
+			nextToken = tlist.NextToken
+		}
+	}
+	if len(taskArns) == 0 {
+		return nil, fmt.Errorf("no Tasks found in cluster %s", cluster)
+	}
+	return taskArns, nil
+}
+
+// DescribeTasks の上限に合わせて分割してタスクを取得
+func DescribeTasks(ctx context.Context, ecsClient ECSAPI, cluster string, taskArns []string) ([]ecsTypes.Task, error) {
+	var tasks []ecsTypes.Task
+	for start := 0; start < len(taskArns); start += describeTasksBatchSize {
+		end := min(start+describeTasksBatchSize, len(taskArns))
+		descOutput, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: &cluster,
+			Tasks:   taskArns[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, descOutput.Tasks...)
+	}
+	return tasks, nil
+}
+
+// 期間内のサービスイベントを TimelineEvent として取得
+func DescribeServiceEvents(ctx context.Context, ecsClient ECSAPI, cluster, serviceName string, window TimeWindow) ([]TimelineEvent, error) {
+	svc, err := describeService(ctx, ecsClient, cluster, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return serviceEvents(svc, window), nil
+}
+
+// サービスの詳細を取得
+func describeService(ctx context.Context, ecsClient ECSAPI, cluster, serviceName string) (ecsTypes.Service, error) {
+	out, err := ecsClient.DescribeServices(ctx, &ecs.DescribeServicesInput{Cluster: &cluster, Services: []string{serviceName}})
+	if err != nil {
+		return ecsTypes.Service{}, err
+	}
+	if len(out.Services) == 0 {
+		return ecsTypes.Service{}, fmt.Errorf("no services found for %s", serviceName)
+	}
+	return out.Services[0], nil
+}
+
+// サービスのイベントのうち期間内のもの
+func serviceEvents(svc ecsTypes.Service, window TimeWindow) []TimelineEvent {
+	// 最新10件のイベントのみを処理
+	// const recentEventsLimit = 10
+	// events := svc.Events
+	// if len(events) > recentEventsLimit {
+	// 	events = events[:recentEventsLimit]
+	// }
+
+	var events []TimelineEvent
+	for _, ev := range svc.Events {
+		ts := aws.ToTime(ev.CreatedAt)
+		if !window.Contains(ts) {
+			continue
+		}
+		msg := aws.ToString(ev.Message)
+		// ソースは "SERVICE"
+		events = append(events, NewEvent(ts, ServiceSource, msg))
+	}
+	return events
+}
+
+// CloudWatch Logs からログイベントを取得し、Timeline に追加
+// 期間指定がなければ末尾から遡り、期間指定があれば先頭から順に読む
+// 読み取り件数と Follow 用の NextForwardToken は stream に記録する
+func fetchCloudWatchLogsToTimeline(ctx context.Context, logsClient LogsAPI, stream *LogStream, opts FetchOptions, timeline *Timeline) error {
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  &stream.Group,
+		LogStreamName: &stream.Stream,
+		StartFromHead: aws.Bool(opts.Window.IsSet()),
+		StartTime:     opts.Window.startMillis(),
+		EndTime:       opts.Window.endMillis(),
+	}
+
+	var events []TimelineEvent
+	var prevToken *string
+	for {
+		remaining := maxGetLogEventsLimit
+		if opts.Limit > 0 {
+			remaining = min(opts.Limit-stream.Read, maxGetLogEventsLimit)
+		}
+		input.Limit = aws.Int32(int32(remaining))
+		input.NextToken = prevToken
+
+		out, err := logsClient.GetLogEvents(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		for _, ev := range out.Events {
+			ts := time.Unix(0, aws.ToInt64(ev.Timestamp)*int64(time.Millisecond))
+			msg := aws.ToString(ev.Message)
+			// ソースはコンテナ名
+			events = append(events, NewEvent(ts, stream.Source, msg))
+		}
+		stream.Read += len(out.Events)
+
+		// 遡って読む場合も Follow は最初に取得した末尾から続ける
+		next := out.NextForwardToken
+		if !opts.Window.IsSet() {
+			if stream.nextToken == nil {
+				stream.nextToken = out.NextForwardToken
+			}
+			next = out.NextBackwardToken
+		} else {
+			stream.nextToken = out.NextForwardToken
+		}
+
+		// トークンが同じなら終了 (ストリームの端に到達)
+		// 途中で0件のページが返ることもあるため件数では判定しない
+		if next == nil || aws.ToString(next) == aws.ToString(prevToken) {
+			break
+		}
+		if opts.Limit > 0 && stream.Read >= opts.Limit {
+			// 残りがちょうど Limit 件だった場合は打ち切りにしないよう、次のページに1件でもあるか確かめる
+			input.Limit = aws.Int32(1)
+			input.NextToken = next
+			probe, err := logsClient.GetLogEvents(ctx, input)
+			if err != nil {
+				return err
+			}
+			stream.Truncated = len(probe.Events) > 0
+			break
+		}
+		prevToken = next
+	}
+
+	// 複数行のログを結合してから時刻順に Timeline へ追加
+	for _, e := range stream.merger.merge(SortedAscending(events)) {
+		timeline.Add(e)
+	}
+
+	// 先頭から読んで打ち切った場合、読み残しを Follow で新着として出さないよう
+	// Follow はストリームの現在の末尾から続ける
+	if opts.Window.IsSet() && stream.Truncated {
+		out, err := logsClient.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  &stream.Group,
+			LogStreamName: &stream.Stream,
+			StartFromHead: aws.Bool(false),
+			Limit:         aws.Int32(1),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to find the end of the log stream: %w", err)
+		}
+		stream.nextToken = out.NextForwardToken
+	}
+	return nil
+}
+
+// コンテナ定義が awslogs ドライバを使っているか判定
+func isAwslogsDriver(logConfig *ecsTypes.LogConfiguration) bool {
+	return logConfig != nil && logConfig.LogDriver == ecsTypes.LogDriverAwslogs
+}
+
+// arnからスラッシュ区切りの末尾要素(TASK_ID等)を抽出
+func ArnToName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
@@ -0,0 +1,70 @@
+package ecstrace
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// タスク内のコンテナの状態
+type ContainerSummary struct {
+	Name        string `json:"name"`
+	Essential   bool   `json:"essential"`
+	LastStatus  string `json:"last_status"`
+	ExitCode    *int32 `json:"exit_code,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Health      string `json:"health_status,omitempty"`
+	ImageDigest string `json:"image_digest,omitempty"`
+	// タスク停止の原因になった essential コンテナ
+	CausedStop bool `json:"caused_stop,omitempty"`
+}
+
+// task.Containers とタスク定義の ContainerDefinitions をコンテナ名で結合する
+func ContainerSummaries(task ecsTypes.Task, def *ecsTypes.TaskDefinition) []ContainerSummary {
+	essential := make(map[string]bool)
+	if def != nil {
+		for _, cdef := range def.ContainerDefinitions {
+			// Essential の既定値は true
+			essential[aws.ToString(cdef.Name)] = cdef.Essential == nil || *cdef.Essential
+		}
+	}
+
+	summaries := make([]ContainerSummary, 0, len(task.Containers))
+	for _, c := range task.Containers {
+		name := aws.ToString(c.Name)
+		ess, ok := essential[name]
+		summaries = append(summaries, ContainerSummary{
+			Name:        name,
+			Essential:   !ok || ess,
+			LastStatus:  aws.ToString(c.LastStatus),
+			ExitCode:    c.ExitCode,
+			Reason:      aws.ToString(c.Reason),
+			Health:      string(c.HealthStatus),
+			ImageDigest: aws.ToString(c.ImageDigest),
+		})
+	}
+	markStopCause(task, summaries)
+	return summaries
+}
+
+// essential コンテナの終了でタスクが停止した場合、原因のコンテナに印を付ける
+// 0 以外で終了した essential コンテナを優先し、なければ停止済みの essential コンテナとする
+func markStopCause(task ecsTypes.Task, summaries []ContainerSummary) {
+	if task.StopCode != ecsTypes.TaskStopCodeEssentialContainerExited {
+		return
+	}
+	found := false
+	for i, s := range summaries {
+		if s.Essential && s.ExitCode != nil && *s.ExitCode != 0 {
+			summaries[i].CausedStop = true
+			found = true
+		}
+	}
+	if found {
+		return
+	}
+	for i, s := range summaries {
+		if s.Essential && s.LastStatus == "STOPPED" && s.ExitCode != nil {
+			summaries[i].CausedStop = true
+		}
+	}
+}
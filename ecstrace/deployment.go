@@ -0,0 +1,133 @@
+package ecstrace
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// ServiceOptions.Deployment で最新のデプロイを指す値
+const LatestDeployment = "latest"
+
+// デプロイの失敗やサーキットブレーカーの作動を示すサービスイベント
+var circuitBreakerPattern = regexp.MustCompile(`(?i)circuit breaker|deployment failed|rolling back|rolled back`)
+
+// ServiceOptions.Deployment で対象にしたデプロイの状態
+type DeploymentSummary struct {
+	ID                 string    `json:"id"`
+	Status             string    `json:"status"`
+	RolloutState       string    `json:"rollout_state,omitempty"`
+	RolloutStateReason string    `json:"rollout_state_reason,omitempty"`
+	TaskDefinition     string    `json:"task_definition"`
+	CreatedAt          time.Time `json:"created_at"`
+	DesiredCount       int32     `json:"desired_count"`
+	RunningCount       int32     `json:"running_count"`
+	PendingCount       int32     `json:"pending_count"`
+	FailedTasks        int32     `json:"failed_tasks"`
+	// デプロイサーキットブレーカーの設定
+	CircuitBreaker         bool `json:"circuit_breaker"`
+	CircuitBreakerRollback bool `json:"circuit_breaker_rollback"`
+}
+
+// サービスのデプロイを ID で探す ("latest" なら最も新しいもの)
+func findDeployment(svc ecsTypes.Service, id string) (ecsTypes.Deployment, error) {
+	if len(svc.Deployments) == 0 {
+		return ecsTypes.Deployment{}, fmt.Errorf("service %s has no deployments", aws.ToString(svc.ServiceName))
+	}
+
+	if id == LatestDeployment {
+		latest := svc.Deployments[0]
+		for _, d := range svc.Deployments[1:] {
+			if aws.ToTime(d.CreatedAt).After(aws.ToTime(latest.CreatedAt)) {
+				latest = d
+			}
+		}
+		return latest, nil
+	}
+
+	var ids []string
+	for _, d := range svc.Deployments {
+		if aws.ToString(d.Id) == id {
+			return d, nil
+		}
+		ids = append(ids, aws.ToString(d.Id))
+	}
+	return ecsTypes.Deployment{}, fmt.Errorf("deployment %s not found (available: %s)", id, strings.Join(ids, ", "))
+}
+
+// デプロイが起動したタスクかどうか
+// ECS はデプロイの ID を StartedBy に設定する。StartedBy がなければタスク定義と作成時刻で判断する
+func startedByDeployment(task ecsTypes.Task, d ecsTypes.Deployment) bool {
+	if startedBy := aws.ToString(task.StartedBy); startedBy != "" {
+		return startedBy == aws.ToString(d.Id)
+	}
+	return aws.ToString(task.TaskDefinitionArn) == aws.ToString(d.TaskDefinition) &&
+		!aws.ToTime(task.CreatedAt).Before(aws.ToTime(d.CreatedAt))
+}
+
+// デプロイが起動したタスクを、実行中・停止済みの両方から取得
+// 失敗したタスクは停止済みになっているため両方を見る
+func listDeploymentTasks(ctx context.Context, ecsClient ECSAPI, cluster, service string, d ecsTypes.Deployment) ([]ecsTypes.Task, error) {
+	var tasks []ecsTypes.Task
+	for _, status := range []ecsTypes.DesiredStatus{ecsTypes.DesiredStatusRunning, ecsTypes.DesiredStatusStopped} {
+		arns, err := listServiceTaskArns(ctx, ecsClient, cluster, service, status)
+		if err != nil {
+			return nil, err
+		}
+		described, err := DescribeTasks(ctx, ecsClient, cluster, arns)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range described {
+			if startedByDeployment(t, d) {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no tasks found for deployment %s", aws.ToString(d.Id))
+	}
+	sortTasksByCreatedAt(tasks)
+	return tasks, nil
+}
+
+func newDeploymentSummary(svc ecsTypes.Service, d ecsTypes.Deployment) DeploymentSummary {
+	s := DeploymentSummary{
+		ID:                 aws.ToString(d.Id),
+		Status:             aws.ToString(d.Status),
+		RolloutState:       string(d.RolloutState),
+		RolloutStateReason: aws.ToString(d.RolloutStateReason),
+		TaskDefinition:     ArnToName(aws.ToString(d.TaskDefinition)),
+		CreatedAt:          aws.ToTime(d.CreatedAt),
+		DesiredCount:       d.DesiredCount,
+		RunningCount:       d.RunningCount,
+		PendingCount:       d.PendingCount,
+		FailedTasks:        d.FailedTasks,
+	}
+	if cfg := svc.DeploymentConfiguration; cfg != nil && cfg.DeploymentCircuitBreaker != nil {
+		s.CircuitBreaker = cfg.DeploymentCircuitBreaker.Enable
+		s.CircuitBreakerRollback = cfg.DeploymentCircuitBreaker.Rollback
+	}
+	return s
+}
+
+// デプロイ作成以降のサービスイベントを返し、サーキットブレーカー関連のイベントは ERROR にする
+func deploymentServiceEvents(events []TimelineEvent, d DeploymentSummary) []TimelineEvent {
+	var kept []TimelineEvent
+	for _, e := range events {
+		if e.Timestamp.Before(d.CreatedAt) {
+			continue
+		}
+		if circuitBreakerPattern.MatchString(e.Message) {
+			e.Level = "ERROR"
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
@@ -0,0 +1,106 @@
+package ecstrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// DescribeLogStreams で探索する最大ページ数 (1ページ最大50件)
+const maxDiscoveryPages = 20
+
+// ロググループまたはストリームがまだ作成されていない
+var ErrLogStreamNotFound = errors.New("log stream does not exist yet")
+
+// CloudWatch Logs の ResourceNotFoundException かどうか
+func isResourceNotFound(err error) bool {
+	var nf *cwlTypes.ResourceNotFoundException
+	return errors.As(err, &nf)
+}
+
+// 計算したストリーム名が使えない場合に、DescribeLogStreams でタスク ID またはコンテナのランタイム ID を含むストリームを探す
+// prefix はストリーム名の固定部分。空ならグループ全体を最後にイベントが書かれた順に探す
+// (awslogs-stream-prefix がなければストリーム名は Docker のコンテナ ID になる)
+func discoverLogStream(ctx context.Context, logsClient LogsAPI, group, prefix, containerName, taskID, runtimeID string) (string, error) {
+	input := &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName: aws.String(group),
+	}
+	if prefix != "" {
+		input.LogStreamNamePrefix = aws.String(prefix)
+	} else {
+		// LogStreamNamePrefix とは併用できない
+		input.OrderBy = cwlTypes.OrderByLastEventTime
+		input.Descending = aws.Bool(true)
+	}
+
+	var streams []cwlTypes.LogStream
+	paginator := cloudwatchlogs.NewDescribeLogStreamsPaginator(logsClient, input)
+	for i := 0; i < maxDiscoveryPages && paginator.HasMorePages(); i++ {
+		out, err := paginator.NextPage(ctx)
+		if isResourceNotFound(err) {
+			return "", fmt.Errorf("log group %s: %w", group, ErrLogStreamNotFound)
+		}
+		if err != nil {
+			return "", err
+		}
+		streams = append(streams, out.LogStreams...)
+		if name, ok := pickLogStream(streams, containerName, taskID, runtimeID); ok {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no stream for task %s in %s: %w", taskID, group, ErrLogStreamNotFound)
+}
+
+// タスク ID またはコンテナのランタイム ID を含むストリームから1件選ぶ
+// ランタイム ID を含むもの、コンテナ名を含むものの順に優先し、同じ条件なら最後にイベントが書かれたものを選ぶ
+func pickLogStream(streams []cwlTypes.LogStream, containerName, taskID, runtimeID string) (string, bool) {
+	var best *cwlTypes.LogStream
+	bestScore := -1
+	for i, s := range streams {
+		name := aws.ToString(s.LogStreamName)
+		byTask := taskID != "" && strings.Contains(name, taskID)
+		byRuntime := runtimeID != "" && strings.Contains(name, runtimeID)
+		if !byTask && !byRuntime {
+			continue
+		}
+		score := 0
+		switch {
+		case byRuntime:
+			score = 2
+		case containerName != "" && strings.Contains(name, containerName):
+			score = 1
+		}
+		if score > bestScore ||
+			score == bestScore && aws.ToInt64(s.LastEventTimestamp) > aws.ToInt64(best.LastEventTimestamp) {
+			best, bestScore = &streams[i], score
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return aws.ToString(best.LogStreamName), true
+}
+
+// タスクのコンテナのランタイム ID (Docker のコンテナ ID)。まだ起動していなければ空
+func containerRuntimeID(task ecsTypes.Task, containerName string) string {
+	for _, c := range task.Containers {
+		if aws.ToString(c.Name) == containerName {
+			return aws.ToString(c.RuntimeId)
+		}
+	}
+	return ""
+}
+
+// テンプレートを含むストリーム名から、先頭の固定部分を取り出す
+func staticStreamPrefix(name string) string {
+	if i := strings.Index(name, "$("); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
@@ -0,0 +1,829 @@
+package ecstrace
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// -----------------------------------------------------------------------------
+// このテストでは、ecs.Client、cloudwatchlogs.Client、およびクラスター名が正しく設定されているかを確認します。
+// ecsClient、logsClient、および cluster が期待通りに設定されていない場合、エラーメッセージを出力します。
+// -----------------------------------------------------------------------------
+func TestNewTaskProcessor(t *testing.T) {
+	ecsClient := &ecs.Client{}
+	logsClient := &cloudwatchlogs.Client{}
+	cluster := "test-cluster"
+
+	processor := NewTaskProcessor(ecsClient, logsClient, cluster)
+
+	if processor.ecsClient != ecsClient {
+		t.Error("ecsClient was not properly set")
+	}
+	if processor.logsClient != logsClient {
+		t.Error("logsClient was not properly set")
+	}
+	if processor.cluster != cluster {
+		t.Errorf("cluster = %s, want %s", processor.cluster, cluster)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// isAwslogsDriver 関数の動作をテストします。
+// テストケース:
+// 1. "nil config": LogConfiguration が nil の場合
+// 2. "awslogs driver": LogDriver が ecsTypes.LogDriverAwslogs の場合 (正常系)
+// 3. "other driver": LogDriver が "json-file" の場合
+// -----------------------------------------------------------------------------
+func TestIsAwslogsDriver(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   *ecsTypes.LogConfiguration
+		expected bool
+	}{
+		{
+			name:     "nil config",
+			config:   nil,
+			expected: false,
+		},
+		{
+			name: "awslogs driver",
+			config: &ecsTypes.LogConfiguration{
+				LogDriver: ecsTypes.LogDriverAwslogs,
+			},
+			expected: true,
+		},
+		{
+			name: "other driver",
+			config: &ecsTypes.LogConfiguration{
+				LogDriver: "json-file",
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := isAwslogsDriver(tc.config)
+			if got != tc.expected {
+				t.Errorf("isAwslogsDriver() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// 正しくEvent構造体を初期化することをテストします。作成されたEventのTimestamp、Source、
+// およびMessageフィールドが期待される値と一致することを確認します。
+// -----------------------------------------------------------------------------
+func TestNewEvent(t *testing.T) {
+	ts := time.Now()
+	source := "TEST"
+	msg := "test message"
+
+	event := NewEvent(ts, source, msg)
+
+	if event.Timestamp != ts {
+		t.Errorf("expected timestamp %v, got %v", ts, event.Timestamp)
+	}
+	if event.Source != source {
+		t.Errorf("expected source %s, got %s", source, event.Source)
+	}
+	if event.Message != msg {
+		t.Errorf("expected message %s, got %s", msg, event.Message)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// このテストは、以下のケースを検証します:
+// 1. タスク ARN からタスク ID を抽出するケース
+// 2. クラスター ARN からクラスター名を抽出するケース
+// 3. シンプルなパスからリソース名を抽出するケース
+// 各テストケースでは、期待される出力と実際の出力を比較し、一致しない場合はエラーメッセージを表示します。
+// -----------------------------------------------------------------------------
+func TestArnToName(t *testing.T) {
+	tests := []struct {
+		name     string
+		arn      string
+		expected string
+	}{
+		{
+			name:     "task ARN",
+			arn:      "arn:aws:ecs:region:account:task/cluster/task-id",
+			expected: "task-id",
+		},
+		{
+			name:     "cluster ARN",
+			arn:      "arn:aws:ecs:region:account:cluster/cluster-name",
+			expected: "cluster-name",
+		},
+		{
+			name:     "simple path",
+			arn:      "path/to/resource",
+			expected: "resource",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ArnToName(tt.arn)
+			if got != tt.expected {
+				t.Errorf("ArnToName(%s) = %s; want %s", tt.arn, got, tt.expected)
+			}
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// AddUnique が既出のイベントを除外することをテストします。
+// テスト内容:
+// 1. Add で追加済みのイベントを AddUnique しても追加されないこと
+// 2. 新しいイベントは追加されること
+// 3. 同じイベントを2回 AddUnique しても1件しか追加されないこと
+// -----------------------------------------------------------------------------
+func TestTimelineAddUnique(t *testing.T) {
+	tl := &Timeline{}
+	ts := time.Date(2023, 1, 1, 15, 04, 05, 0, time.UTC)
+
+	tl.Add(NewEvent(ts, "app", "first"))
+
+	if tl.AddUnique(NewEvent(ts, "app", "first")) {
+		t.Error("duplicate event was added")
+	}
+	if !tl.AddUnique(NewEvent(ts, "app", "second")) {
+		t.Error("new event was not added")
+	}
+	if tl.AddUnique(NewEvent(ts, "app", "second")) {
+		t.Error("duplicate event was added twice")
+	}
+	if len(tl.events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(tl.events))
+	}
+}
+
+// -----------------------------------------------------------------------------
+// timeWindow.contains が期間の両端を含めて判定することをテストします。
+// -----------------------------------------------------------------------------
+func TestTimeWindowContains(t *testing.T) {
+	start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	w := TimeWindow{Start: start, End: end}
+
+	if !w.Contains(start) || !w.Contains(end) {
+		t.Error("window should include its bounds")
+	}
+	if w.Contains(start.Add(-time.Second)) || w.Contains(end.Add(time.Second)) {
+		t.Error("window should exclude times outside of it")
+	}
+	if !(TimeWindow{}).Contains(start) {
+		t.Error("zero window should include every time")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// eventFilter のテストです。
+// テストケース:
+// 1. "grep": -grep に一致するメッセージのみ残す
+// 2. "grep ignore case": -ignore-case で大文字小文字を区別しない
+// 3. "exclude": -exclude に一致するメッセージを除外する
+// 4. "source": -source で指定したソースのみ残す (大文字小文字は区別しない)
+// -----------------------------------------------------------------------------
+func TestEventFilter(t *testing.T) {
+	events := []TimelineEvent{
+		{Source: "app", Message: "GET /health 200"},
+		{Source: "app", Message: "ERROR database timeout"},
+		{Source: "SERVICE", Message: "service has reached a steady state."},
+		{Source: "sidecar", Message: "error: connection refused"},
+	}
+
+	tests := []struct {
+		name       string
+		grep       string
+		exclude    string
+		sources    string
+		ignoreCase bool
+		expected   []string
+	}{
+		{name: "grep", grep: "error", expected: []string{"error: connection refused"}},
+		{
+			name:       "grep ignore case",
+			grep:       "error",
+			ignoreCase: true,
+			expected:   []string{"ERROR database timeout", "error: connection refused"},
+		},
+		{
+			name:     "exclude",
+			exclude:  "/health",
+			expected: []string{"ERROR database timeout", "service has reached a steady state.", "error: connection refused"},
+		},
+		{
+			name:     "source",
+			sources:  "service, sidecar",
+			expected: []string{"service has reached a steady state.", "error: connection refused"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.grep, tt.exclude, tt.sources, "", tt.ignoreCase)
+			if err != nil {
+				t.Fatalf("NewFilter() error: %v", err)
+			}
+			tl := &Timeline{events: append([]TimelineEvent(nil), events...)}
+			tl.ApplyFilter(f)
+
+			var got []string
+			for _, e := range tl.events {
+				got = append(got, e.Message)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+			if tl.filtered != len(events)-len(tt.expected) {
+				t.Errorf("filtered = %d, want %d", tl.filtered, len(events)-len(tt.expected))
+			}
+		})
+	}
+
+	if _, err := NewFilter("(", "", "", "", false); err == nil {
+		t.Error("invalid regex should return error")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// parseStructuredLog のテストです。
+// テストケース:
+// 1. "plain text": JSON でない行は解析しない
+// 2. "common keys": msg / level / logger / trace_id を正規化して抽出し、残りを Fields に入れる
+// 3. "nested and aliases": ネストしたキーや severity / traceId などの別名も扱う
+// 4. "numeric level": pino 形式の数値レベルを変換する
+// -----------------------------------------------------------------------------
+func TestParseStructuredLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		ok       bool
+		expected StructuredLog
+	}{
+		{name: "plain text", msg: "GET /health 200", ok: false},
+		{
+			name: "common keys",
+			msg:  `{"time":"2024-01-02T00:00:00Z","level":"warning","msg":"slow query","logger":"db","trace_id":"abc","duration_ms":1200}`,
+			ok:   true,
+			expected: StructuredLog{
+				Level:   "WARN",
+				Body:    "slow query",
+				Logger:  "db",
+				TraceID: "abc",
+				Fields:  map[string]string{"duration_ms": "1200"},
+			},
+		},
+		{
+			name: "nested and aliases",
+			msg:  `{"severity":"ERROR","message":"failed","traceId":"xyz","http":{"status":500}}`,
+			ok:   true,
+			expected: StructuredLog{
+				Level:   "ERROR",
+				Body:    "failed",
+				TraceID: "xyz",
+				Fields:  map[string]string{"http.status": "500"},
+			},
+		},
+		{
+			name:     "numeric level",
+			msg:      `{"level":30,"msg":"listening"}`,
+			ok:       true,
+			expected: StructuredLog{Level: "INFO", Body: "listening", Fields: map[string]string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseStructuredLog(tt.msg)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.Level != tt.expected.Level || got.Body != tt.expected.Body ||
+				got.Logger != tt.expected.Logger || got.TraceID != tt.expected.TraceID {
+				t.Errorf("got %+v, want %+v", got, tt.expected)
+			}
+			if len(got.Fields) != len(tt.expected.Fields) {
+				t.Fatalf("fields = %v, want %v", got.Fields, tt.expected.Fields)
+			}
+			for k, v := range tt.expected.Fields {
+				if got.Fields[k] != v {
+					t.Errorf("fields[%s] = %s, want %s", k, got.Fields[k], v)
+				}
+			}
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// detectLevel が JSON 以外のログからレベルを推定することをテストします。
+// 行頭 (タイムスタンプ・括弧付きのトークンの後) のレベルのみを使い、
+// 文中の "error" / "info" などの単語ではレベルを付けないことも確認します。
+// -----------------------------------------------------------------------------
+func TestDetectLevel(t *testing.T) {
+	tests := []struct {
+		msg      string
+		expected string
+	}{
+		{"ERROR something failed", "ERROR"},
+		{"[warn] disk almost full", "WARN"},
+		{"2024-01-02 10:00:00,123 WARNING [main] slow", "WARN"},
+		{`time=2024-01-02T10:00:00Z level=error msg="boom"`, "ERROR"},
+		{"lvl=debug cache miss", "DEBUG"},
+		{"Traceback (most recent call last):", "ERROR"},
+		{"\tat com.example.App.main(App.java:10)", "ERROR"},
+		{"java.lang.NullPointerException: null", "ERROR"},
+		{"panic: runtime error: index out of range", "FATAL"},
+		{"GET /health 200", ""},
+		{"2024-01-02T10:00:00Z [worker-1] (db) error: connection reset", "ERROR"},
+		{"no error found", ""},
+		{"retrying after error 503", ""},
+		{"request completed without error", ""},
+		{"GET /health info 200", ""},
+	}
+
+	for _, tt := range tests {
+		if got := detectLevel(tt.msg); got != tt.expected {
+			t.Errorf("detectLevel(%q) = %q, want %q", tt.msg, got, tt.expected)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// -level のしきい値で、それ未満のイベントを除外することをテストします。
+// レベルを判定できないイベントは INFO として扱います。
+// -----------------------------------------------------------------------------
+func TestEventFilterLevel(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tl := &Timeline{}
+	tl.Add(NewEvent(ts, "app", "DEBUG cache miss"))
+	tl.Add(NewEvent(ts, "app", `{"level":"info","msg":"request done"}`))
+	tl.Add(NewEvent(ts, "app", "WARN slow request"))
+	tl.Add(NewEvent(ts, "app", `{"level":"error","msg":"request failed"}`))
+	tl.Add(NewEvent(ts, "SERVICE", "service has reached a steady state."))
+
+	f, err := NewFilter("", "", "", "warn", false)
+	if err != nil {
+		t.Fatalf("NewFilter() error: %v", err)
+	}
+	tl.ApplyFilter(f)
+
+	if len(tl.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(tl.events))
+	}
+	if tl.events[0].Level != "WARN" || tl.events[1].Level != "ERROR" {
+		t.Errorf("unexpected levels: %s, %s", tl.events[0].Level, tl.events[1].Level)
+	}
+
+	if _, err := NewFilter("", "", "", "verbose", false); err == nil {
+		t.Error("unknown level should return error")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// multilineMerger のテストです。
+// テストケース:
+// 1. "pattern": 先頭行の正規表現に一致しない行を直前のイベントに連結する
+// 2. "datetime format": awslogs-datetime-format (strftime) で先頭行を判定する
+// 3. "disabled": どちらも未指定なら結合しない
+// -----------------------------------------------------------------------------
+func TestMultilineMerger(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	lines := []string{
+		"2024-01-02 00:00:00 INFO start",
+		"2024-01-02 00:00:01 request failed",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.App.run(App.java:10)",
+		"2024-01-02 00:00:02 INFO done",
+	}
+	var events []TimelineEvent
+	for i, l := range lines {
+		events = append(events, NewEvent(ts.Add(time.Duration(i)*time.Millisecond), "app", l))
+	}
+
+	tests := []struct {
+		name           string
+		pattern        string
+		datetimeFormat string
+		expected       int
+	}{
+		{name: "pattern", pattern: `^\d{4}-\d{2}-\d{2}`, expected: 3},
+		{name: "datetime format", datetimeFormat: "%Y-%m-%d %H:%M:%S", expected: 3},
+		{name: "disabled", expected: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMultilineMerger(tt.pattern, tt.datetimeFormat)
+			if err != nil {
+				t.Fatalf("newMultilineMerger() error: %v", err)
+			}
+			got := m.merge(events)
+			if len(got) != tt.expected {
+				t.Fatalf("got %d events, want %d", len(got), tt.expected)
+			}
+			if tt.expected == 3 {
+				want := strings.Join(lines[1:4], "\n")
+				if got[1].Message != want {
+					t.Errorf("merged message = %q, want %q", got[1].Message, want)
+				}
+				// 先頭行にレベルがなくてもスタックトレースから ERROR と判定する
+				if got[1].Level != "ERROR" {
+					t.Errorf("merged level = %q, want ERROR", got[1].Level)
+				}
+			}
+		})
+	}
+
+	if _, err := newMultilineMerger("", "%Q"); err == nil {
+		t.Error("unsupported strftime directive should return error")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// TaskLifecycleEvents のテストです。
+// テスト内容:
+// 1. 設定されている時刻のみ TASK イベントになること
+// 2. 停止イベントに StoppedReason と StopCode が含まれ、StopCode に応じたレベルになること
+// -----------------------------------------------------------------------------
+func TestTaskLifecycleEvents(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	at := func(sec int) *time.Time {
+		ts := base.Add(time.Duration(sec) * time.Second)
+		return &ts
+	}
+	task := ecsTypes.Task{
+		CreatedAt:     at(0),
+		PullStartedAt: at(1),
+		PullStoppedAt: at(5),
+		StartedAt:     at(6),
+		StoppingAt:    at(60),
+		StoppedAt:     at(65),
+		StopCode:      ecsTypes.TaskStopCodeEssentialContainerExited,
+		StoppedReason: aws.String("Essential container in task exited"),
+	}
+
+	events := TaskLifecycleEvents(task)
+	if len(events) != 6 {
+		t.Fatalf("expected 6 events, got %d", len(events))
+	}
+	for _, e := range events {
+		if e.Source != "TASK" {
+			t.Errorf("source = %s, want TASK", e.Source)
+		}
+	}
+
+	stopped := events[len(events)-1]
+	want := "Task stopped: Essential container in task exited (StopCode: EssentialContainerExited)"
+	if stopped.Message != want {
+		t.Errorf("message = %q, want %q", stopped.Message, want)
+	}
+	if stopped.Level != "WARN" {
+		t.Errorf("level = %q, want WARN", stopped.Level)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// containerLogDestination のテストです。
+// テストケース:
+// 1. "awslogs": prefix/コンテナ名/タスクID のストリームと awslogs-region のリージョンになること
+// 2. "firelens log_stream_name": $(ecs_task_id) 等のテンプレートが展開されること
+// 3. "firelens log_stream_prefix": prefix + "<コンテナ名>-firelens-<タスクID>" になること
+// 4. "firelens other output": CloudWatch 以外の出力は対象外になること
+// 5. "firelens unsupported template": 解決できない変数があればストリームを空にし、固定部分で探すこと
+// 6. "firelens without group": log_group_name がなければエラーになること
+// -----------------------------------------------------------------------------
+func TestContainerLogDestination(t *testing.T) {
+	taskArn := "arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/abc123"
+	firelens := func(options map[string]string) *ecsTypes.LogConfiguration {
+		return &ecsTypes.LogConfiguration{LogDriver: ecsTypes.LogDriverAwsfirelens, Options: options}
+	}
+
+	testCases := []struct {
+		name      string
+		config    *ecsTypes.LogConfiguration
+		wantOK    bool
+		wantDest  logDestination
+		wantError bool
+	}{
+		{
+			name: "awslogs",
+			config: &ecsTypes.LogConfiguration{
+				LogDriver: ecsTypes.LogDriverAwslogs,
+				Options: map[string]string{
+					"awslogs-group":         "/ecs/app",
+					"awslogs-stream-prefix": "ecs",
+					"awslogs-region":        "us-east-1",
+				},
+			},
+			wantOK:   true,
+			wantDest: logDestination{group: "/ecs/app", stream: "ecs/app/abc123", prefix: "ecs/", region: "us-east-1"},
+		},
+		{
+			name: "firelens log_stream_name",
+			config: firelens(map[string]string{
+				"Name":            "cloudwatch_logs",
+				"log_group_name":  "/firelens/$(ecs_cluster)",
+				"log_stream_name": "app/$(ecs_task_id)",
+			}),
+			wantOK:   true,
+			wantDest: logDestination{group: "/firelens/my-cluster", stream: "app/abc123", prefix: "app/"},
+		},
+		{
+			name: "firelens log_stream_prefix",
+			config: firelens(map[string]string{
+				"Name":              "cloudwatch",
+				"log_group_name":    "/firelens/app",
+				"log_stream_prefix": "from-fluent-bit-",
+			}),
+			wantOK:   true,
+			wantDest: logDestination{group: "/firelens/app", stream: "from-fluent-bit-app-firelens-abc123", prefix: "from-fluent-bit-"},
+		},
+		{
+			name:   "firelens other output",
+			config: firelens(map[string]string{"Name": "datadog"}),
+			wantOK: false,
+		},
+		{
+			name: "firelens unsupported template",
+			config: firelens(map[string]string{
+				"Name":            "cloudwatch_logs",
+				"log_group_name":  "/firelens/app",
+				"log_stream_name": "app-$(kubernetes['pod_name'])",
+			}),
+			wantOK:   true,
+			wantDest: logDestination{group: "/firelens/app", prefix: "app-"},
+		},
+		{
+			name:      "firelens without group",
+			config:    firelens(map[string]string{"Name": "cloudwatch_logs", "log_stream_prefix": "app-"}),
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cdef := ecsTypes.ContainerDefinition{Name: aws.String("app"), LogConfiguration: tc.config}
+			dest, ok, err := containerLogDestination(cdef, taskArn)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error = %v, wantError %v", err, tc.wantError)
+			}
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if ok && dest != tc.wantDest {
+				t.Errorf("destination = %+v, want %+v", dest, tc.wantDest)
+			}
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// pickLogStream のテストです。
+// テスト内容:
+// 1. タスク ID もランタイム ID も含まないストリームは選ばれないこと
+// 2. コンテナ名も含むストリームが優先されること
+// 3. 同じ条件なら最後にイベントが書かれたストリームが選ばれること
+// 4. awslogs-stream-prefix がない場合の、ランタイム ID (コンテナ ID) 名のストリームが選ばれること
+// -----------------------------------------------------------------------------
+func TestPickLogStream(t *testing.T) {
+	stream := func(name string, last int64) cwlTypes.LogStream {
+		return cwlTypes.LogStream{LogStreamName: aws.String(name), LastEventTimestamp: aws.Int64(last)}
+	}
+
+	if _, ok := pickLogStream([]cwlTypes.LogStream{stream("ecs/app/other", 1)}, "app", "abc123", "f00d"); ok {
+		t.Error("stream without the task ID should not be picked")
+	}
+
+	streams := []cwlTypes.LogStream{
+		stream("custom/sidecar/abc123", 30),
+		stream("custom/app/abc123", 10),
+		stream("custom/app/abc123-retry", 20),
+	}
+	got, ok := pickLogStream(streams, "app", "abc123", "")
+	if !ok || got != "custom/app/abc123-retry" {
+		t.Errorf("pickLogStream() = %q, %v; want custom/app/abc123-retry", got, ok)
+	}
+
+	streams = []cwlTypes.LogStream{
+		stream("c0ffee", 30),
+		stream("f00d", 10),
+		stream("custom/app/abc123", 20),
+	}
+	got, ok = pickLogStream(streams, "app", "abc123", "f00d")
+	if !ok || got != "f00d" {
+		t.Errorf("pickLogStream() = %q, %v; want f00d", got, ok)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// TaskProcessor.logsClientFor のテストです。
+// テスト内容:
+// 1. リージョン未指定・既定のリージョンでは既定のクライアントを返すこと
+// 2. 別のリージョンではそのリージョンのクライアントを作成し、使い回すこと
+// -----------------------------------------------------------------------------
+func TestTaskProcessorLogsClientFor(t *testing.T) {
+	logsClient := cloudwatchlogs.New(cloudwatchlogs.Options{Region: "ap-northeast-1"})
+	processor := NewTaskProcessor(&ecs.Client{}, logsClient, "test-cluster")
+
+	if processor.logsClientFor("") != logsClient {
+		t.Error("empty region should use the default client")
+	}
+	if processor.logsClientFor("ap-northeast-1") != logsClient {
+		t.Error("default region should use the default client")
+	}
+
+	other := processor.logsClientFor("us-east-1")
+	if other == logsClient {
+		t.Fatal("other region should use a different client")
+	}
+	if got := other.(*cloudwatchlogs.Client).Options().Region; got != "us-east-1" {
+		t.Errorf("region = %s, want us-east-1", got)
+	}
+	if processor.logsClientFor("us-east-1") != other {
+		t.Error("client for the same region should be cached")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// -deployment 用の補助関数のテストです。
+// テスト内容:
+// 1. findDeployment が "latest" で最新のデプロイを、ID で一致するデプロイを返すこと
+// 2. startedByDeployment が StartedBy、なければタスク定義と作成時刻で判定すること
+// 3. deploymentServiceEvents がデプロイ前のイベントを除き、サーキットブレーカーのイベントを ERROR にすること
+// -----------------------------------------------------------------------------
+func TestDeployment(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	oldDeploy := ecsTypes.Deployment{
+		Id:             aws.String("ecs-svc/111"),
+		Status:         aws.String("ACTIVE"),
+		TaskDefinition: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:1"),
+		CreatedAt:      aws.Time(base),
+	}
+	newDeploy := ecsTypes.Deployment{
+		Id:                 aws.String("ecs-svc/222"),
+		Status:             aws.String("PRIMARY"),
+		TaskDefinition:     aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:2"),
+		CreatedAt:          aws.Time(base.Add(time.Hour)),
+		RolloutState:       ecsTypes.DeploymentRolloutStateFailed,
+		RolloutStateReason: aws.String("ECS deployment circuit breaker: tasks failed to start."),
+		DesiredCount:       2,
+		FailedTasks:        3,
+	}
+	svc := ecsTypes.Service{
+		ServiceName: aws.String("web"),
+		Deployments: []ecsTypes.Deployment{oldDeploy, newDeploy},
+		DeploymentConfiguration: &ecsTypes.DeploymentConfiguration{
+			DeploymentCircuitBreaker: &ecsTypes.DeploymentCircuitBreaker{Enable: true, Rollback: true},
+		},
+	}
+
+	if d, err := findDeployment(svc, "latest"); err != nil || aws.ToString(d.Id) != "ecs-svc/222" {
+		t.Errorf("findDeployment(latest) = %v, %v", aws.ToString(d.Id), err)
+	}
+	if d, err := findDeployment(svc, "ecs-svc/111"); err != nil || aws.ToString(d.Id) != "ecs-svc/111" {
+		t.Errorf("findDeployment(ecs-svc/111) = %v, %v", aws.ToString(d.Id), err)
+	}
+	if _, err := findDeployment(svc, "ecs-svc/999"); err == nil {
+		t.Error("findDeployment should fail for an unknown ID")
+	}
+
+	if !startedByDeployment(ecsTypes.Task{StartedBy: aws.String("ecs-svc/222")}, newDeploy) {
+		t.Error("task started by the deployment should match")
+	}
+	if startedByDeployment(ecsTypes.Task{StartedBy: aws.String("ecs-svc/111")}, newDeploy) {
+		t.Error("task started by another deployment should not match")
+	}
+	manual := ecsTypes.Task{TaskDefinitionArn: newDeploy.TaskDefinition, CreatedAt: aws.Time(base.Add(2 * time.Hour))}
+	if !startedByDeployment(manual, newDeploy) {
+		t.Error("task without StartedBy should match by task definition and creation time")
+	}
+
+	summary := newDeploymentSummary(svc, newDeploy)
+	events := deploymentServiceEvents([]TimelineEvent{
+		NewEvent(base.Add(30*time.Minute), "SERVICE", "(service web) has reached a steady state."),
+		NewEvent(base.Add(2*time.Hour), "SERVICE", "(service web) (deployment ecs-svc/222) deployment failed: tasks failed to start."),
+		NewEvent(base.Add(3*time.Hour), "SERVICE", "(service web) has started 1 tasks: (task abc)."),
+	}, summary)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events after the deployment, got %d", len(events))
+	}
+	if events[0].Level != "ERROR" || events[1].Level == "ERROR" {
+		t.Errorf("levels = %q, %q; want ERROR for the circuit breaker event only", events[0].Level, events[1].Level)
+	}
+}
+
+// 固定のイベントを返す EventSource
+type staticSource struct {
+	name   string
+	events []TimelineEvent
+}
+
+func (s staticSource) Name() string { return s.name }
+
+func (s staticSource) Fetch(ctx context.Context, window TimeWindow) ([]TimelineEvent, error) {
+	return s.events, nil
+}
+
+// -----------------------------------------------------------------------------
+// イベントソースの登録と選択のテストです。
+// テスト内容:
+// 1. RegisterSource で登録したソースが組み込みのソースの後に並ぶこと
+// 2. ParseSources が未登録のソース名をエラーにすること
+// 3. newSources がスコープと FetchOptions.Sources で絞り込み、対象外 (nil) のソースを除くこと
+// 4. 同じ名前の二重登録は panic すること
+// -----------------------------------------------------------------------------
+func TestSourceRegistry(t *testing.T) {
+	orig := registry
+	defer func() { registry = orig }()
+
+	RegisterSource("custom", TaskScope, func(p *TaskProcessor, target SourceTarget) EventSource {
+		return staticSource{name: "custom"}
+	})
+	if got := strings.Join(SourceNames(), ","); got != "service,task,logs,custom" {
+		t.Errorf("SourceNames() = %s", got)
+	}
+
+	names, err := ParseSources(" Logs, custom ")
+	if err != nil || strings.Join(names, ",") != "logs,custom" {
+		t.Errorf("ParseSources() = %v, %v", names, err)
+	}
+	if _, err := ParseSources("metrics"); err == nil {
+		t.Error("ParseSources() should fail for an unknown source")
+	}
+
+	p := NewTaskProcessor(&ecs.Client{}, &cloudwatchlogs.Client{}, "test-cluster")
+	task := ecsTypes.Task{TaskArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/test-cluster/abc")}
+	sourceNames := func(scope SourceScope, target SourceTarget) string {
+		var got []string
+		for _, src := range p.newSources(scope, target) {
+			got = append(got, src.Name())
+		}
+		return strings.Join(got, ",")
+	}
+
+	// タスク定義がなければ logs は作られない
+	if got := sourceNames(TaskScope, SourceTarget{Task: &task}); got != "task,custom" {
+		t.Errorf("task scope sources = %s, want task,custom", got)
+	}
+	if got := sourceNames(TaskScope, SourceTarget{Task: &task, Fetch: FetchOptions{Sources: []string{"custom"}}}); got != "custom" {
+		t.Errorf("selected sources = %s, want custom", got)
+	}
+	// サービスに属さなければ service は作られない
+	if got := sourceNames(ServiceScope, SourceTarget{}); got != "" {
+		t.Errorf("service scope sources = %s, want none", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate registration should panic")
+		}
+	}()
+	RegisterSource(TaskSourceName, TaskScope, newTaskEventsSource)
+}
+
+// -----------------------------------------------------------------------------
+// -query 用の補助関数のテストです。
+// テスト内容:
+// 1. buildTaskQuery がストリーム名の一覧と、タスク ID・ランタイム ID で @logStream を絞り込むこと
+// 2. queryRange が期間未指定ならタスクの作成から停止の1分後 (実行中なら現在) までになること
+// -----------------------------------------------------------------------------
+func TestTaskQuery(t *testing.T) {
+	got := buildTaskQuery(" | stats count(*) by bin(1m) ", []string{"ecs/app/abc", `odd"name`}, []string{"abc"})
+	want := `filter @logStream in ["ecs/app/abc", "odd\"name"] or @logStream like /abc/` + "\n| stats count(*) by bin(1m)"
+	if got != want {
+		t.Errorf("buildTaskQuery() = %q, want %q", got, want)
+	}
+	if got := buildTaskQuery("fields @message", nil, []string{"abc", "f00d"}); got != "filter @logStream like /abc|f00d/\n| fields @message" {
+		t.Errorf("buildTaskQuery() = %q", got)
+	}
+
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	now := base.Add(time.Hour)
+	task := ecsTypes.Task{CreatedAt: aws.Time(base), StoppedAt: aws.Time(base.Add(10 * time.Minute))}
+	if start, end := queryRange(task, TimeWindow{}, now); !start.Equal(base) || !end.Equal(base.Add(11*time.Minute)) {
+		t.Errorf("stopped task range = [%v, %v]", start, end)
+	}
+	running := ecsTypes.Task{CreatedAt: aws.Time(base)}
+	if _, end := queryRange(running, TimeWindow{}, now); !end.Equal(now) {
+		t.Errorf("running task end = %v, want %v", end, now)
+	}
+	w := TimeWindow{Start: base.Add(time.Minute), End: base.Add(2 * time.Minute)}
+	if start, end := queryRange(task, w, now); !start.Equal(w.Start) || !end.Equal(w.End) {
+		t.Errorf("window range = [%v, %v]", start, end)
+	}
+}
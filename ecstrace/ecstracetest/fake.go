@@ -0,0 +1,283 @@
+// Package ecstracetest は ecstrace.ECSAPI / ecstrace.LogsAPI のメモリ上のフェイクを提供する
+// AWS に接続せずに ecstrace を使うコードをテストするために使う
+package ecstracetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"logs-ecstask/ecstrace"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// ecstrace.ECSAPI と ecstrace.LogsAPI を実装するメモリ上のフェイク
+// クラスター・タスク・タスク定義・サービス・ログストリームを事前に登録しておく
+type FakeAWS struct {
+	Clusters []string
+	// ARN をキーにしたタスクとタスク定義
+	tasks    map[string]ecsTypes.Task
+	defs     map[string]ecsTypes.TaskDefinition
+	services map[string]ecsTypes.Service
+	// ロググループ -> ストリーム名 -> 古い順のイベント
+	logs map[string]map[string][]cwlTypes.OutputLogEvent
+	// ストリーム名ごとに GetLogEvents が返すエラー
+	LogErrors map[string]error
+	// GetLogEvents で受け取ったリクエスト
+	LogEventsRequests []cloudwatchlogs.GetLogEventsInput
+	// GetQueryResults が返す行と、StartQuery で受け取ったクエリ
+	QueryResults   [][]cwlTypes.ResultField
+	StartedQueries []cloudwatchlogs.StartQueryInput
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// GetLogEvents の1回あたりの最大取得件数 (Limit 未指定時)
+const maxGetLogEventsLimit = 10000
+
+var (
+	_ ecstrace.ECSAPI  = (*FakeAWS)(nil)
+	_ ecstrace.LogsAPI = (*FakeAWS)(nil)
+)
+
+func NewFakeAWS() *FakeAWS {
+	return &FakeAWS{
+		tasks:     make(map[string]ecsTypes.Task),
+		defs:      make(map[string]ecsTypes.TaskDefinition),
+		services:  make(map[string]ecsTypes.Service),
+		logs:      make(map[string]map[string][]cwlTypes.OutputLogEvent),
+		LogErrors: make(map[string]error),
+		calls:     make(map[string]int),
+	}
+}
+
+// API の呼び出しを記録
+func (f *FakeAWS) record(api string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[api]++
+}
+
+// API ごとの呼び出し回数
+func (f *FakeAWS) CallCount(api string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[api]
+}
+
+func (f *FakeAWS) AddTask(task ecsTypes.Task) {
+	f.tasks[aws.ToString(task.TaskArn)] = task
+}
+
+func (f *FakeAWS) AddTaskDefinition(def ecsTypes.TaskDefinition) {
+	f.defs[aws.ToString(def.TaskDefinitionArn)] = def
+}
+
+func (f *FakeAWS) AddService(svc ecsTypes.Service) {
+	f.services[aws.ToString(svc.ServiceName)] = svc
+}
+
+func (f *FakeAWS) RemoveService(name string) {
+	delete(f.services, name)
+}
+
+// ストリームにメッセージを追加する (timestamps はミリ秒)
+func (f *FakeAWS) AddLogs(group, stream string, timestamps []int64, messages []string) {
+	if f.logs[group] == nil {
+		f.logs[group] = make(map[string][]cwlTypes.OutputLogEvent)
+	}
+	for i, msg := range messages {
+		f.logs[group][stream] = append(f.logs[group][stream], cwlTypes.OutputLogEvent{
+			Timestamp: aws.Int64(timestamps[i]),
+			Message:   aws.String(msg),
+		})
+	}
+}
+
+func (f *FakeAWS) ListClusters(ctx context.Context, params *ecs.ListClustersInput, optFns ...func(*ecs.Options)) (*ecs.ListClustersOutput, error) {
+	f.record("ListClusters")
+	var arns []string
+	for _, c := range f.Clusters {
+		arns = append(arns, "arn:aws:ecs:ap-northeast-1:123456789012:cluster/"+c)
+	}
+	return &ecs.ListClustersOutput{ClusterArns: arns}, nil
+}
+
+func (f *FakeAWS) ListTasks(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+	f.record("ListTasks")
+	var arns []string
+	for arn, t := range f.tasks {
+		if !strings.Contains(arn, ":task/"+aws.ToString(params.Cluster)+"/") {
+			continue
+		}
+		if params.ServiceName != nil && aws.ToString(t.Group) != "service:"+aws.ToString(params.ServiceName) {
+			continue
+		}
+		if params.DesiredStatus != "" && aws.ToString(t.DesiredStatus) != string(params.DesiredStatus) {
+			continue
+		}
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	return &ecs.ListTasksOutput{TaskArns: arns}, nil
+}
+
+// タスクは ARN と ID のどちらでも指定できる
+func (f *FakeAWS) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	f.record("DescribeTasks")
+	out := &ecs.DescribeTasksOutput{}
+	for _, id := range params.Tasks {
+		for arn, t := range f.tasks {
+			if arn == id || ecstrace.ArnToName(arn) == id {
+				out.Tasks = append(out.Tasks, t)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *FakeAWS) DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	f.record("DescribeTaskDefinition")
+	def, ok := f.defs[aws.ToString(params.TaskDefinition)]
+	if !ok {
+		return nil, fmt.Errorf("task definition not found: %s", aws.ToString(params.TaskDefinition))
+	}
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &def}, nil
+}
+
+func (f *FakeAWS) DescribeServices(ctx context.Context, params *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+	f.record("DescribeServices")
+	out := &ecs.DescribeServicesOutput{}
+	for _, name := range params.Services {
+		if svc, ok := f.services[name]; ok {
+			out.Services = append(out.Services, svc)
+		}
+	}
+	return out, nil
+}
+
+// GetLogEvents のページングを再現する
+// トークンは "f:<位置>" (先頭方向から) と "b:<位置>" (末尾方向から) で、端に達すると同じトークンを返す
+func (f *FakeAWS) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	f.record("GetLogEvents")
+	f.mu.Lock()
+	f.LogEventsRequests = append(f.LogEventsRequests, *params)
+	f.mu.Unlock()
+	stream := aws.ToString(params.LogStreamName)
+	if err := f.LogErrors[stream]; err != nil {
+		return nil, err
+	}
+	streams, ok := f.logs[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, &cwlTypes.ResourceNotFoundException{Message: aws.String("The specified log group does not exist.")}
+	}
+	all, ok := streams[stream]
+	if !ok {
+		return nil, &cwlTypes.ResourceNotFoundException{Message: aws.String("The specified log stream does not exist.")}
+	}
+
+	var events []cwlTypes.OutputLogEvent
+	for _, e := range all {
+		ts := aws.ToInt64(e.Timestamp)
+		if params.StartTime != nil && ts < *params.StartTime {
+			continue
+		}
+		if params.EndTime != nil && ts >= *params.EndTime {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	limit := int(aws.ToInt32(params.Limit))
+	if limit <= 0 {
+		limit = maxGetLogEventsLimit
+	}
+
+	var start, end int
+	switch token := aws.ToString(params.NextToken); {
+	case strings.HasPrefix(token, "f:"):
+		start, _ = strconv.Atoi(token[2:])
+		end = min(start+limit, len(events))
+	case strings.HasPrefix(token, "b:"):
+		end, _ = strconv.Atoi(token[2:])
+		start = max(end-limit, 0)
+	case aws.ToBool(params.StartFromHead):
+		start, end = 0, min(limit, len(events))
+	default:
+		start, end = max(len(events)-limit, 0), len(events)
+	}
+
+	return &cloudwatchlogs.GetLogEventsOutput{
+		Events:            events[start:end],
+		NextForwardToken:  aws.String(fmt.Sprintf("f:%d", end)),
+		NextBackwardToken: aws.String(fmt.Sprintf("b:%d", start)),
+	}, nil
+}
+
+// OrderBy が LastEventTime なら最後のイベントの時刻順、それ以外はストリーム名順に返す
+func (f *FakeAWS) DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	f.record("DescribeLogStreams")
+	byLastEvent := params.OrderBy == cwlTypes.OrderByLastEventTime
+	if byLastEvent && params.LogStreamNamePrefix != nil {
+		return nil, &cwlTypes.InvalidParameterException{Message: aws.String("Cannot order by LastEventTime with a logStreamNamePrefix.")}
+	}
+	streams, ok := f.logs[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, &cwlTypes.ResourceNotFoundException{Message: aws.String("The specified log group does not exist.")}
+	}
+
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	for name, events := range streams {
+		if !strings.HasPrefix(name, aws.ToString(params.LogStreamNamePrefix)) {
+			continue
+		}
+		s := cwlTypes.LogStream{LogStreamName: aws.String(name)}
+		if len(events) > 0 {
+			s.LastEventTimestamp = events[len(events)-1].Timestamp
+		}
+		out.LogStreams = append(out.LogStreams, s)
+	}
+	less := func(a, b cwlTypes.LogStream) bool {
+		if byLastEvent {
+			return aws.ToInt64(a.LastEventTimestamp) < aws.ToInt64(b.LastEventTimestamp)
+		}
+		return aws.ToString(a.LogStreamName) < aws.ToString(b.LogStreamName)
+	}
+	sort.Slice(out.LogStreams, func(i, j int) bool {
+		if aws.ToBool(params.Descending) {
+			return less(out.LogStreams[j], out.LogStreams[i])
+		}
+		return less(out.LogStreams[i], out.LogStreams[j])
+	})
+	return out, nil
+}
+
+// クエリは評価せず、QueryResults をそのまま返す
+func (f *FakeAWS) StartQuery(ctx context.Context, params *cloudwatchlogs.StartQueryInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StartQueryOutput, error) {
+	f.record("StartQuery")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.StartedQueries = append(f.StartedQueries, *params)
+	return &cloudwatchlogs.StartQueryOutput{QueryId: aws.String(fmt.Sprintf("query-%d", len(f.StartedQueries)))}, nil
+}
+
+func (f *FakeAWS) GetQueryResults(ctx context.Context, params *cloudwatchlogs.GetQueryResultsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	f.record("GetQueryResults")
+	return &cloudwatchlogs.GetQueryResultsOutput{
+		Status:  cwlTypes.QueryStatusComplete,
+		Results: f.QueryResults,
+		Statistics: &cwlTypes.QueryStatistics{
+			RecordsMatched: float64(len(f.QueryResults)),
+			RecordsScanned: float64(len(f.QueryResults)),
+		},
+	}, nil
+}
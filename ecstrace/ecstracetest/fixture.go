@@ -0,0 +1,99 @@
+package ecstracetest
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// NewWebFixture が登録するクラスター・タスク・タスク定義・デプロイ
+const (
+	Cluster           = "my-cluster"
+	TaskID            = "0123456789abcdef"
+	TaskArn           = "arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/" + TaskID
+	TaskDefinitionArn = "arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:1"
+	DeploymentID      = "ecs-svc/222"
+	// sidecar の Docker のコンテナ ID
+	SidecarRuntimeID = "5e1dcafe5e1dcafe5e1dcafe5e1dcafe5e1dcafe5e1dcafe5e1dcafe5e1dcafe"
+)
+
+// タスクの作成時刻。フィクスチャの時刻はこれを基準にする
+var Base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Base から d 後の時刻 (ミリ秒)
+func Millis(d time.Duration) int64 {
+	return Base.Add(d).UnixMilli()
+}
+
+// サービス web の実行中のタスク (コンテナ app / sidecar / worker)
+func NewWebTask(taskArn string) ecsTypes.Task {
+	return ecsTypes.Task{
+		TaskArn:           aws.String(taskArn),
+		TaskDefinitionArn: aws.String(TaskDefinitionArn),
+		Group:             aws.String("service:web"),
+		DesiredStatus:     aws.String("RUNNING"),
+		LastStatus:        aws.String("RUNNING"),
+		StartedBy:         aws.String(DeploymentID),
+		CreatedAt:         aws.Time(Base),
+		StartedAt:         aws.Time(Base.Add(10 * time.Second)),
+		Containers: []ecsTypes.Container{
+			{Name: aws.String("app"), LastStatus: aws.String("RUNNING")},
+			{Name: aws.String("sidecar"), LastStatus: aws.String("RUNNING"), RuntimeId: aws.String(SidecarRuntimeID)},
+			{Name: aws.String("worker"), LastStatus: aws.String("RUNNING")},
+		},
+	}
+}
+
+// サービス web のタスク TaskID を1つ登録した FakeAWS
+// タスクは次の3コンテナを持つ
+//   - app: awslogs (接頭辞あり)。計算したストリーム名で取得できる
+//   - sidecar: awslogs (接頭辞なし)。ストリーム名はランタイム ID で、DescribeLogStreams で探す
+//   - worker: awslogs (接頭辞あり)。ストリームがまだない
+func NewWebFixture() *FakeAWS {
+	f := NewFakeAWS()
+	f.Clusters = []string{Cluster}
+	f.AddTask(NewWebTask(TaskArn))
+
+	awslogs := func(group, prefix string) *ecsTypes.LogConfiguration {
+		options := map[string]string{"awslogs-group": group}
+		if prefix != "" {
+			options["awslogs-stream-prefix"] = prefix
+		}
+		return &ecsTypes.LogConfiguration{LogDriver: ecsTypes.LogDriverAwslogs, Options: options}
+	}
+	f.AddTaskDefinition(ecsTypes.TaskDefinition{
+		TaskDefinitionArn: aws.String(TaskDefinitionArn),
+		ContainerDefinitions: []ecsTypes.ContainerDefinition{
+			{Name: aws.String("app"), LogConfiguration: awslogs("/ecs/web", "ecs")},
+			{Name: aws.String("sidecar"), LogConfiguration: awslogs("/ecs/sidecar", ""), Essential: aws.Bool(false)},
+			{Name: aws.String("worker"), LogConfiguration: awslogs("/ecs/worker", "ecs"), Essential: aws.Bool(false)},
+		},
+	})
+	f.AddService(ecsTypes.Service{
+		ServiceName: aws.String("web"),
+		Events: []ecsTypes.ServiceEvent{
+			{CreatedAt: aws.Time(Base.Add(5 * time.Second)), Message: aws.String("(service web) has started 1 tasks.")},
+		},
+		Deployments: []ecsTypes.Deployment{
+			{
+				Id:             aws.String(DeploymentID),
+				Status:         aws.String("PRIMARY"),
+				TaskDefinition: aws.String(TaskDefinitionArn),
+				CreatedAt:      aws.Time(Base.Add(-time.Minute)),
+				RolloutState:   ecsTypes.DeploymentRolloutStateInProgress,
+			},
+		},
+	})
+
+	f.AddLogs("/ecs/web", "ecs/app/"+TaskID,
+		[]int64{Millis(20 * time.Second), Millis(21 * time.Second), Millis(22 * time.Second)},
+		[]string{"server started", `{"level":"error","msg":"db timeout"}`, "GET /health 200"})
+	f.AddLogs("/ecs/sidecar", SidecarRuntimeID,
+		[]int64{Millis(15 * time.Second)},
+		[]string{"proxy ready"})
+	f.AddLogs("/ecs/worker", "ecs/worker/other-task",
+		[]int64{Millis(15 * time.Second)},
+		[]string{"belongs to another task"})
+	return f
+}
@@ -0,0 +1,40 @@
+package ecstrace
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// GetLogEvents の1回あたりの最大取得件数
+const maxGetLogEventsLimit = 10000
+
+// FetchOptions.Limit の既定値
+const DefaultLogLimit = 400
+
+// FetchOptions.Concurrency の既定値
+const DefaultFetchConcurrency = 4
+
+// スロットリング時の最大試行回数
+const logsRetryMaxAttempts = 10
+
+// イベント取得のオプション
+type FetchOptions struct {
+	Window TimeWindow
+	// コンテナごとの最大取得件数。0 なら全件
+	Limit int
+	// 複数行ログの先頭行の正規表現。指定があればコンテナの awslogs 設定より優先する
+	MultilinePattern string
+	// 同時に取得するコンテナ数
+	Concurrency int
+	// ソース名の末尾に付ける文字列 (TraceService では "@shortTaskID")
+	SourceSuffix string
+	// 取得するイベントソース名。空なら登録済みの全ソース
+	Sources []string
+}
+
+// CloudWatch Logs クライアントの設定
+// 並列取得でスロットリングされた場合は adaptive モードで送信レートを落として再試行する
+func WithThrottleRetry(o *cloudwatchlogs.Options) {
+	o.RetryMode = aws.RetryModeAdaptive
+	o.RetryMaxAttempts = logsRetryMaxAttempts
+}
@@ -0,0 +1,94 @@
+package ecstrace
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Timeline のイベントを絞り込むフィルタ
+type Filter struct {
+	grep    *regexp.Regexp
+	exclude *regexp.Regexp
+	// 空なら全ソースを対象にする
+	sources []string
+	// 最低レベルの重要度。-1 なら絞り込まない
+	minLevel int
+}
+
+// メッセージの正規表現 (grep / exclude)・カンマ区切りのソース・最低レベルからフィルタを作成
+// 何も指定されていなければ nil を返す
+func NewFilter(grep, exclude, sources, level string, ignoreCase bool) (*Filter, error) {
+	if grep == "" && exclude == "" && sources == "" && level == "" {
+		return nil, nil
+	}
+
+	f := &Filter{}
+	var err error
+	if f.minLevel, err = parseLevelThreshold(level); err != nil {
+		return nil, err
+	}
+	if f.grep, err = compileFilterPattern(grep, ignoreCase); err != nil {
+		return nil, fmt.Errorf("invalid grep pattern: %w", err)
+	}
+	if f.exclude, err = compileFilterPattern(exclude, ignoreCase); err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	for _, s := range strings.Split(sources, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.sources = append(f.sources, s)
+		}
+	}
+	return f, nil
+}
+
+// 空文字なら nil を返す
+func compileFilterPattern(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// イベントがフィルタ条件に一致するか (nil なら常に一致)
+func (f *Filter) Match(e TimelineEvent) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.sources) > 0 && !f.matchSource(e.Source) {
+		return false
+	}
+	if f.minLevel >= 0 && f.eventRank(e) < f.minLevel {
+		return false
+	}
+	if f.grep != nil && !f.grep.MatchString(e.Message) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(e.Message) {
+		return false
+	}
+	return true
+}
+
+// ソース名は大文字小文字を区別しない (SERVICE / service)
+// TraceService の "container@shortTaskID" はコンテナ名だけでも一致させる
+func (f *Filter) matchSource(source string) bool {
+	container, _, _ := strings.Cut(source, "@")
+	for _, s := range f.sources {
+		if strings.EqualFold(s, source) || strings.EqualFold(s, container) {
+			return true
+		}
+	}
+	return false
+}
+
+// レベルを判定できなかったイベントは INFO として扱う
+func (f *Filter) eventRank(e TimelineEvent) int {
+	if r := levelRank(e.Level); r >= 0 {
+		return r
+	}
+	return levelRanks["INFO"]
+}
@@ -0,0 +1,139 @@
+package ecstrace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// FireLens で CloudWatch Logs に送る Fluent Bit の出力プラグイン名
+var firelensCloudWatchOutputs = map[string]bool{
+	"cloudwatch":      true,
+	"cloudwatch_logs": true,
+}
+
+// コンテナのログの送り先 (CloudWatch Logs のロググループとストリーム)
+type logDestination struct {
+	group  string
+	stream string
+	// stream が見つからない場合に DescribeLogStreams で探すときの接頭辞
+	// stream が空ならストリーム名を決められないので最初から探す
+	prefix string
+	// ロググループのリージョン (空なら既定のリージョン)
+	region string
+}
+
+func isFirelensDriver(logConfig *ecsTypes.LogConfiguration) bool {
+	return logConfig != nil && logConfig.LogDriver == ecsTypes.LogDriverAwsfirelens
+}
+
+// コンテナ定義のログ設定からストリームを解決する
+// awslogs と、CloudWatch に送る awsfirelens に対応する
+// FireLens のルーター自身は通常 awslogs を使うので、他のコンテナと同じく独立したソースになる
+func containerLogDestination(cdef ecsTypes.ContainerDefinition, taskArn string) (logDestination, bool, error) {
+	logConfig := cdef.LogConfiguration
+	containerName := aws.ToString(cdef.Name)
+	taskID := ArnToName(taskArn)
+
+	switch {
+	case isAwslogsDriver(logConfig):
+		dest := logDestination{
+			group:  logConfig.Options["awslogs-group"],
+			region: logConfig.Options["awslogs-region"],
+		}
+		// 接頭辞がなければストリーム名はタスク ID から決まらない
+		if prefix := logConfig.Options["awslogs-stream-prefix"]; prefix != "" {
+			dest.stream = fmt.Sprintf("%s/%s/%s", prefix, containerName, taskID)
+			dest.prefix = prefix + "/"
+		}
+		return dest, true, nil
+
+	case isFirelensDriver(logConfig):
+		if !firelensCloudWatchOutputs[logConfig.Options["Name"]] {
+			return logDestination{}, false, nil
+		}
+		dest, ok, err := firelensDestination(logConfig.Options, containerName, taskArn)
+		dest.region = logConfig.Options["region"]
+		return dest, ok, err
+	}
+	return logDestination{}, false, nil
+}
+
+// awsfirelens の cloudwatch / cloudwatch_logs 出力の設定からストリームを解決する
+// log_stream_name が優先され、なければ log_stream_prefix + タグになる
+// タグは FireLens が付ける "<コンテナ名>-firelens-<タスクID>"
+// log_stream_name に解決できない変数がある場合は固定部分を接頭辞にして探す
+func firelensDestination(options map[string]string, containerName, taskArn string) (logDestination, bool, error) {
+	group := options["log_group_name"]
+	if group == "" {
+		return logDestination{}, false, fmt.Errorf("awsfirelens option log_group_name is not set")
+	}
+
+	vars := firelensTemplateVars(containerName, taskArn)
+	group, err := expandFirelensTemplate(group, vars)
+	if err != nil {
+		return logDestination{}, false, err
+	}
+
+	switch {
+	case options["log_stream_name"] != "":
+		name := options["log_stream_name"]
+		dest := logDestination{group: group, prefix: staticStreamPrefix(name)}
+		if stream, err := expandFirelensTemplate(name, vars); err == nil {
+			dest.stream = stream
+		}
+		return dest, true, nil
+	case options["log_stream_prefix"] != "":
+		prefix := options["log_stream_prefix"]
+		return logDestination{group: group, stream: prefix + vars["tag"], prefix: prefix}, true, nil
+	}
+	return logDestination{}, false, fmt.Errorf("awsfirelens option log_stream_name or log_stream_prefix is not set")
+}
+
+// $(...) テンプレートで使える変数
+func firelensTemplateVars(containerName, taskArn string) map[string]string {
+	taskID := ArnToName(taskArn)
+
+	// arn:aws:ecs:<region>:<account>:task/<cluster>/<task id>
+	var cluster string
+	if _, resource, ok := strings.Cut(taskArn, ":task/"); ok {
+		if c, _, ok := strings.Cut(resource, "/"); ok {
+			cluster = c
+		}
+	}
+
+	return map[string]string{
+		"ecs_task_id":    taskID,
+		"ecs_task_arn":   taskArn,
+		"ecs_cluster":    cluster,
+		"container_name": containerName,
+		"tag":            containerName + "-firelens-" + taskID,
+	}
+}
+
+// "$(ecs_task_id)" のような変数を置き換える
+// 解決できない変数 (レコードのキー参照など) が残る場合はエラーを返す
+func expandFirelensTemplate(s string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "$(")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.Index(s[start:], ")")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated template in %q", s)
+		}
+		name := s[start+2 : start+end]
+		v, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("unsupported template variable $(%s)", name)
+		}
+		b.WriteString(s[:start])
+		b.WriteString(v)
+		s = s[start+end+1:]
+	}
+}
@@ -0,0 +1,223 @@
+package ecstrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// TaskProcessor.FollowInterval が未指定の場合の Follow のポーリング間隔
+const DefaultFollowInterval = 5 * time.Second
+
+// コンテナのログストリームと、その読み取り位置
+type LogStream struct {
+	Group  string
+	Stream string
+	// 取得元のコンテナ名と、イベントのソース名
+	Container string
+	Source    string
+	// 初回取得で読んだ件数と、Limit で打ち切ったかどうか
+	Read      int
+	Truncated bool
+	// ストリームが見つからない理由
+	Missing error
+	// 初回取得に失敗した理由 (Follow でも取得しない)
+	Err error
+
+	// Stream が空の間は streamPrefix とタスク ID・コンテナのランタイム ID で DescribeLogStreams から探す
+	streamPrefix string
+	taskID       string
+	runtimeID    string
+	nextToken    *string
+	// ロググループのリージョンの CloudWatch Logs クライアント
+	client LogsAPI
+	// 複数行ログの結合設定 (nil なら結合しない)
+	merger *multilineMerger
+}
+
+// Follow で新しいイベントを監視するための状態
+type follower struct {
+	processor *TaskProcessor
+	trace     *TaskTrace
+}
+
+// TraceTask の後に新しいイベントを監視し、ポーリングのたびに新規イベントを fn に渡す
+// TraceTask で取得したソースのうち FollowSource を実装するものが対象
+// ctx がキャンセルされるか、タスクが STOPPED になるまで続ける
+// fn がエラーを返した場合はそのエラーで終了する
+func (p *TaskProcessor) Follow(ctx context.Context, trace *TaskTrace, fn func([]TimelineEvent) error) error {
+	f := &follower{processor: p, trace: trace}
+	return f.run(ctx, fn)
+}
+
+// ctx がキャンセルされるか、タスクが STOPPED になるまでポーリングを続ける
+func (f *follower) run(ctx context.Context, fn func([]TimelineEvent) error) error {
+	interval := f.processor.FollowInterval
+	if interval <= 0 {
+		interval = DefaultFollowInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		task, err := f.describeTask(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			f.processor.logger().Warnf("failed to describe task: %v", err)
+		}
+		// TASK イベントのソースは trace.Task を参照する
+		if task != nil {
+			f.trace.Task = *task
+		}
+
+		// 停止していても最後のログを取りこぼさないよう一度は取得する
+		if err := fn(f.poll(ctx)); err != nil {
+			return err
+		}
+
+		if task != nil && aws.ToString(task.LastStatus) == "STOPPED" {
+			f.processor.logger().Progressf("Task reached STOPPED.")
+			return nil
+		}
+	}
+}
+
+// タスクの最新の状態を取得
+func (f *follower) describeTask(ctx context.Context) (*ecsTypes.Task, error) {
+	taskArn := aws.ToString(f.trace.Task.TaskArn)
+	out, err := f.processor.getTaskDetails(ctx, taskArn)
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Tasks) == 0 {
+		return nil, fmt.Errorf("task not found: %s", taskArn)
+	}
+	return &out.Tasks[0], nil
+}
+
+// 期間内かつ未出のイベントを Timeline に追加し、追加したものを返す
+func (f *follower) addUnique(events []TimelineEvent) []TimelineEvent {
+	var added []TimelineEvent
+	for _, e := range events {
+		if f.trace.window.Contains(e.Timestamp) && f.trace.Timeline.AddUnique(e) {
+			added = append(added, e)
+		}
+	}
+	return added
+}
+
+// 各ソースを1回ポーリングし、新規イベントを返す
+func (f *follower) poll(ctx context.Context) []TimelineEvent {
+	var added []TimelineEvent
+	for _, src := range f.trace.sources {
+		fs, ok := src.(FollowSource)
+		if !ok {
+			continue
+		}
+		events, err := fs.Follow(ctx)
+		if err != nil && ctx.Err() == nil {
+			f.processor.logger().Warnf("failed to poll %s events: %v", src.Name(), err)
+		}
+		added = append(added, f.addUnique(events)...)
+	}
+	return added
+}
+
+// 全ストリームの新しいログを取得する
+// ストリームごとの失敗は警告して読み飛ばす
+func (s *cloudWatchLogsSource) Follow(ctx context.Context) ([]TimelineEvent, error) {
+	var events []TimelineEvent
+	logger := s.processor.logger()
+
+	for _, stream := range s.streams {
+		if stream.Err != nil {
+			continue
+		}
+		// まだストリームがなかったコンテナは作成されるのを待つ
+		if stream.Stream == "" {
+			// ランタイム ID はコンテナの起動後に決まる
+			if id := containerRuntimeID(*s.task, stream.Container); id != "" {
+				stream.runtimeID = id
+			}
+			if err := stream.discover(ctx); err != nil {
+				if ctx.Err() == nil {
+					logger.Warnf("failed to discover log stream for container=%s: %v", stream.Source, err)
+				}
+				continue
+			}
+			if stream.Stream == "" {
+				continue
+			}
+			logger.Progressf("%s: found log stream %s", stream.Source, stream.Stream)
+		}
+
+		polled, err := pollLogStream(ctx, stream.client, stream)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warnf("failed to poll logs for container=%s: %v", stream.Source, err)
+			}
+			continue
+		}
+		events = append(events, polled...)
+	}
+	return events, nil
+}
+
+// DescribeLogStreams でストリームを探し、見つかれば s.Stream に設定する
+// 見つからないことはエラーにせず、理由を s.Missing に残す
+func (s *LogStream) discover(ctx context.Context) error {
+	stream, err := discoverLogStream(ctx, s.client, s.Group, s.streamPrefix, s.Container, s.taskID, s.runtimeID)
+	if errors.Is(err, ErrLogStreamNotFound) {
+		s.Missing = err
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.Stream, s.Missing = stream, nil
+	return nil
+}
+
+// 前回の NextForwardToken 以降のログイベントを取得し、読み取り位置を進める
+func pollLogStream(ctx context.Context, logsClient LogsAPI, s *LogStream) ([]TimelineEvent, error) {
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  &s.Group,
+		LogStreamName: &s.Stream,
+		StartFromHead: aws.Bool(true),
+	}
+
+	const maxIteration = 10
+	var events []TimelineEvent
+	for i := 0; i < maxIteration; i++ {
+		input.NextToken = s.nextToken
+		out, err := logsClient.GetLogEvents(ctx, input)
+		if err != nil {
+			return events, err
+		}
+		for _, ev := range out.Events {
+			ts := time.Unix(0, aws.ToInt64(ev.Timestamp)*int64(time.Millisecond))
+			events = append(events, NewEvent(ts, s.Source, aws.ToString(ev.Message)))
+		}
+
+		// トークンが変わらなければ新しいイベントはない
+		next := out.NextForwardToken
+		if next == nil || aws.ToString(next) == aws.ToString(s.nextToken) {
+			break
+		}
+		s.nextToken = next
+	}
+	return s.merger.merge(events), nil
+}
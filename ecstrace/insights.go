@@ -0,0 +1,248 @@
+package ecstrace
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// ストリームからコンテナを特定できない Insights の結果のソース名
+const QuerySource = "INSIGHTS"
+
+// GetQueryResults のポーリング間隔
+const queryPollInterval = time.Second
+
+// 停止後に書き込まれたログも含めるため、停止時刻から延ばす時間
+const queryStopMargin = time.Minute
+
+// Insights の @timestamp の形式 (UTC)
+const queryTimestampLayout = "2006-01-02 15:04:05.000"
+
+// QueryTask の結果
+type QueryResult struct {
+	Task       ecsTypes.Task
+	Containers []ContainerSummary
+	LogGroups  []string
+	// @logStream の絞り込みを加えた、実行したクエリ (リージョンごと)
+	Queries []string
+	// 結果の列名 (@ptr を除き、最初に現れた順) と行
+	Fields []string
+	Rows   []map[string]string
+	// 全リージョンの合計
+	RecordsMatched float64
+	RecordsScanned float64
+
+	// ストリーム名 -> ソース名 (コンテナ名)
+	sources map[string]string
+}
+
+// クエリを実行するロググループと、タスクのストリームの条件
+type queryTarget struct {
+	groups []string
+	// ストリーム名が決まっているもの
+	streams []string
+	// ストリーム名が決まらないコンテナのストリームに含まれる ID (タスク ID・ランタイム ID)
+	streamIDs []string
+}
+
+// タスク定義からタスクのロググループを求め、CloudWatch Logs Insights のクエリを実行する
+// クエリの前にタスクのストリームに絞り込む filter を加える
+// window が未指定なら、タスクの作成から停止 (実行中なら現在) までを対象にする
+func (p *TaskProcessor) QueryTask(ctx context.Context, taskID, query string, window TimeWindow) (*QueryResult, error) {
+	descOut, err := p.getTaskDetails(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tasks: %w", err)
+	}
+	if len(descOut.Tasks) == 0 {
+		return nil, fmt.Errorf("task not found: %s", taskID)
+	}
+	task := descOut.Tasks[0]
+	taskArn := aws.ToString(task.TaskArn)
+
+	defOut, err := p.getTaskDefinition(ctx, task.TaskDefinitionArn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe task definition: %w", err)
+	}
+
+	result := &QueryResult{
+		Task:       task,
+		Containers: ContainerSummaries(task, defOut.TaskDefinition),
+		sources:    make(map[string]string),
+	}
+
+	// Insights のクエリはリージョンごとに実行する
+	targets := make(map[string]*queryTarget)
+	for _, cdef := range defOut.TaskDefinition.ContainerDefinitions {
+		dest, ok, err := containerLogDestination(cdef, taskArn)
+		if err != nil {
+			p.logger().Warnf("%s: cannot resolve log stream: %v", aws.ToString(cdef.Name), err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		t := targets[dest.region]
+		if t == nil {
+			t = &queryTarget{}
+			targets[dest.region] = t
+		}
+		if !contains(t.groups, dest.group) {
+			t.groups = append(t.groups, dest.group)
+			result.LogGroups = append(result.LogGroups, dest.group)
+		}
+		if dest.stream == "" {
+			for _, id := range []string{ArnToName(taskArn), containerRuntimeID(task, aws.ToString(cdef.Name))} {
+				if id != "" && !contains(t.streamIDs, id) {
+					t.streamIDs = append(t.streamIDs, id)
+				}
+			}
+			continue
+		}
+		if !contains(t.streams, dest.stream) {
+			t.streams = append(t.streams, dest.stream)
+		}
+		result.sources[dest.stream] = aws.ToString(cdef.Name)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no CloudWatch Logs log groups in task definition %s", ArnToName(aws.ToString(task.TaskDefinitionArn)))
+	}
+
+	start, end := queryRange(task, window, time.Now())
+	regions := make([]string, 0, len(targets))
+	for region := range targets {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	p.logger().Progressf("Running Insights query on %d log groups...", len(result.LogGroups))
+	for _, region := range regions {
+		t := targets[region]
+		q := buildTaskQuery(query, t.streams, t.streamIDs)
+		result.Queries = append(result.Queries, q)
+		out, err := runQuery(ctx, p.logsClientFor(region), &cloudwatchlogs.StartQueryInput{
+			LogGroupNames: t.groups,
+			QueryString:   aws.String(q),
+			StartTime:     aws.Int64(start.Unix()),
+			EndTime:       aws.Int64(end.Unix()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.addRows(out.Results)
+		if out.Statistics != nil {
+			result.RecordsMatched += out.Statistics.RecordsMatched
+			result.RecordsScanned += out.Statistics.RecordsScanned
+		}
+	}
+	return result, nil
+}
+
+// クエリの前にタスクのストリームに絞り込む filter を加える
+func buildTaskQuery(query string, streams, streamIDs []string) string {
+	var conds []string
+	if len(streams) > 0 {
+		quoted := make([]string, len(streams))
+		for i, s := range streams {
+			quoted[i] = strconv.Quote(s)
+		}
+		conds = append(conds, "@logStream in ["+strings.Join(quoted, ", ")+"]")
+	}
+	if len(streamIDs) > 0 {
+		// タスク ID・ランタイム ID は英数字と "-" のため正規表現としてそのまま使える
+		conds = append(conds, "@logStream like /"+strings.Join(streamIDs, "|")+"/")
+	}
+	query = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(query), "|"))
+	return "filter " + strings.Join(conds, " or ") + "\n| " + query
+}
+
+// 対象の期間 (window の指定がなければタスクの作成から停止まで)
+func queryRange(task ecsTypes.Task, window TimeWindow, now time.Time) (time.Time, time.Time) {
+	start, end := window.Start, window.End
+	if start.IsZero() {
+		start = aws.ToTime(task.CreatedAt)
+	}
+	if end.IsZero() {
+		end = now
+		if task.StoppedAt != nil && task.StoppedAt.Add(queryStopMargin).Before(now) {
+			end = task.StoppedAt.Add(queryStopMargin)
+		}
+	}
+	return start, end
+}
+
+// クエリを開始し、完了するまで結果をポーリングする
+func runQuery(ctx context.Context, logsClient LogsAPI, input *cloudwatchlogs.StartQueryInput) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	started, err := logsClient.StartQuery(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start query: %w", err)
+	}
+
+	for {
+		out, err := logsClient.GetQueryResults(ctx, &cloudwatchlogs.GetQueryResultsInput{QueryId: started.QueryId})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get query results: %w", err)
+		}
+		switch out.Status {
+		case cwlTypes.QueryStatusComplete:
+			return out, nil
+		case cwlTypes.QueryStatusScheduled, cwlTypes.QueryStatusRunning:
+		default:
+			return nil, fmt.Errorf("query %s: %s", aws.ToString(started.QueryId), out.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(queryPollInterval):
+		}
+	}
+}
+
+// 結果の行を追加する (@ptr は除く)
+func (r *QueryResult) addRows(rows [][]cwlTypes.ResultField) {
+	for _, fields := range rows {
+		row := make(map[string]string, len(fields))
+		for _, f := range fields {
+			name := aws.ToString(f.Field)
+			if name == "@ptr" {
+				continue
+			}
+			if !contains(r.Fields, name) {
+				r.Fields = append(r.Fields, name)
+			}
+			row[name] = aws.ToString(f.Value)
+		}
+		r.Rows = append(r.Rows, row)
+	}
+}
+
+// 結果に @timestamp と @message があり、Timeline のイベントにできるか
+func (r *QueryResult) HasEvents() bool {
+	return contains(r.Fields, "@timestamp") && contains(r.Fields, "@message")
+}
+
+// 結果の行を TimelineEvent に変換する
+// ソースは @logStream のコンテナ名 (特定できなければ QuerySource)。時刻を解釈できない行は除く
+func (r *QueryResult) Events() []TimelineEvent {
+	var events []TimelineEvent
+	for _, row := range r.Rows {
+		ts, err := time.Parse(queryTimestampLayout, row["@timestamp"])
+		if err != nil {
+			continue
+		}
+		source, ok := r.sources[row["@logStream"]]
+		if !ok {
+			source = QuerySource
+		}
+		events = append(events, NewEvent(ts, source, row["@message"]))
+	}
+	return events
+}
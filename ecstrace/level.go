@@ -0,0 +1,75 @@
+package ecstrace
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// レベルの重要度 (大きいほど重要)
+var levelRanks = map[string]int{
+	"TRACE": 0,
+	"DEBUG": 1,
+	"INFO":  2,
+	"WARN":  3,
+	"ERROR": 4,
+	"FATAL": 5,
+}
+
+var (
+	// "level=error" / "lvl=warn" (logfmt)
+	logfmtLevelPattern = regexp.MustCompile(`(?i)\b(?:level|lvl|severity)="?([a-z]+)"?`)
+
+	// 行頭の "ERROR" / "[warn]" / "WARNING:" など
+	// 先頭のタイムスタンプ・PID (数字で始まるトークン) と "[main]" のような括弧付きのトークンだけを
+	// 最大3つまで読み飛ばす (任意の単語は飛ばさないので "no error found" はレベルにしない)
+	prefixLevelPattern = regexp.MustCompile(`(?i)^(?:(?:\d[\dTZ:,./+-]*|\[[^\]]*\]|\([^)]*\))\s+){0,3}?[\[(<]?(fatal|critical|panic|error|err|warning|warn|info|notice|debug|trace)[\])>:]?(?:\s|$)`)
+
+	// スタックトレースの行
+	stackTracePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^Traceback \(most recent call last\)`),
+		regexp.MustCompile(`^Exception in thread `),
+		regexp.MustCompile(`^\s+at \S+\(.*\)$`),
+		regexp.MustCompile(`^Caused by: `),
+		regexp.MustCompile(`^(?:[\w$]+\.)+[\w$]*(?:Exception|Error)(?::|$)`),
+		regexp.MustCompile(`^panic: `),
+		regexp.MustCompile(`^goroutine \d+ \[`),
+	}
+)
+
+// JSON 以外のログからレベルを推定する。判定できなければ空文字
+func detectLevel(msg string) string {
+	if m := logfmtLevelPattern.FindStringSubmatch(msg); m != nil {
+		if level := normalizeLevel(m[1]); levelRank(level) >= 0 {
+			return level
+		}
+	}
+	if m := prefixLevelPattern.FindStringSubmatch(msg); m != nil {
+		return normalizeLevel(m[1])
+	}
+	for _, p := range stackTracePatterns {
+		if p.MatchString(msg) {
+			return "ERROR"
+		}
+	}
+	return ""
+}
+
+// レベルの重要度。未知のレベルは -1
+func levelRank(level string) int {
+	if r, ok := levelRanks[level]; ok {
+		return r
+	}
+	return -1
+}
+
+// 最低レベルの指定を重要度に変換
+func parseLevelThreshold(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	r := levelRank(normalizeLevel(s))
+	if r < 0 {
+		return -1, fmt.Errorf("unknown level: %s (trace, debug, info, warn, error, fatal)", s)
+	}
+	return r, nil
+}
@@ -0,0 +1,73 @@
+package ecstrace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// タスクのライフサイクルイベントとサービスイベントのソース名
+const (
+	TaskSource    = "TASK"
+	ServiceSource = "SERVICE"
+)
+
+// タスクの各時刻 (作成・イメージ取得・起動・停止など) を TimelineEvent にする
+// 未到達の時刻 (nil) は含めない
+func TaskLifecycleEvents(task ecsTypes.Task) []TimelineEvent {
+	milestones := []struct {
+		at  *time.Time
+		msg string
+	}{
+		{task.CreatedAt, "Task created"},
+		{task.PullStartedAt, "Image pull started"},
+		{task.PullStoppedAt, "Image pull finished"},
+		{task.StartedAt, "Task started"},
+		{task.ConnectivityAt, "Network connectivity established"},
+		{task.StoppingAt, "Task stopping"},
+		{task.ExecutionStoppedAt, "Task execution stopped"},
+	}
+
+	var events []TimelineEvent
+	for _, m := range milestones {
+		if m.at == nil {
+			continue
+		}
+		events = append(events, NewEvent(aws.ToTime(m.at), TaskSource, m.msg))
+	}
+
+	// 停止理由は -follow で重複しないよう StoppedAt のイベントにだけ付ける
+	if task.StoppedAt != nil {
+		e := NewEvent(aws.ToTime(task.StoppedAt), TaskSource, "Task stopped"+stopDescription(task))
+		e.Level = stopLevel(task.StopCode)
+		events = append(events, e)
+	}
+	return events
+}
+
+// 停止理由と StopCode の説明 (停止していなければ空文字)
+func stopDescription(task ecsTypes.Task) string {
+	reason := aws.ToString(task.StoppedReason)
+	switch {
+	case task.StopCode != "" && reason != "":
+		return fmt.Sprintf(": %s (StopCode: %s)", reason, task.StopCode)
+	case task.StopCode != "":
+		return fmt.Sprintf(" (StopCode: %s)", task.StopCode)
+	case reason != "":
+		return ": " + reason
+	}
+	return ""
+}
+
+// StopCode から停止イベントのレベルを決める (通常の停止はレベルなし)
+func stopLevel(code ecsTypes.TaskStopCode) string {
+	switch code {
+	case ecsTypes.TaskStopCodeTaskFailedToStart:
+		return "ERROR"
+	case ecsTypes.TaskStopCodeEssentialContainerExited, ecsTypes.TaskStopCodeSpotInterruption:
+		return "WARN"
+	}
+	return ""
+}
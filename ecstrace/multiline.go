@@ -0,0 +1,129 @@
+package ecstrace
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// awslogs-datetime-format で使える strftime 指定子と対応する正規表現
+var strftimePatterns = map[byte]string{
+	'Y': `\d{4}`,
+	'y': `\d{2}`,
+	'm': `\d{2}`,
+	'd': `\d{2}`,
+	'e': `[ \d]\d`,
+	'j': `\d{3}`,
+	'H': `\d{2}`,
+	'I': `\d{2}`,
+	'M': `\d{2}`,
+	'S': `\d{2}`,
+	'f': `\d{1,9}`,
+	'L': `\d{3}`,
+	'p': `(?:AM|PM|am|pm)`,
+	'b': `[A-Za-z]{3}`,
+	'B': `[A-Za-z]+`,
+	'a': `[A-Za-z]{3}`,
+	'A': `[A-Za-z]+`,
+	'w': `\d`,
+	'z': `(?:Z|[+-]\d{2}:?\d{2})`,
+	'Z': `[A-Za-z]+`,
+	'T': `\d{2}:\d{2}:\d{2}`,
+	'%': `%`,
+}
+
+// 複数行にまたがるログ (スタックトレース等) を1イベントにまとめる
+// start に一致する行を新しいイベントの先頭とし、それ以外の行は直前のイベントに連結する
+type multilineMerger struct {
+	start *regexp.Regexp
+}
+
+// 複数行ログの先頭行の正規表現 (FetchOptions.MultilinePattern) を検証する
+func ValidateMultilinePattern(pattern string) error {
+	_, err := newMultilineMerger(pattern, "")
+	return err
+}
+
+// awslogs の LogConfiguration と同じ考え方で merger を作成
+// datetimeFormat が指定されていれば pattern より優先する (awslogs と同じ)
+// どちらも空なら nil を返す
+func newMultilineMerger(pattern, datetimeFormat string) (*multilineMerger, error) {
+	if datetimeFormat != "" {
+		re, err := strftimeToRegexp(datetimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		return &multilineMerger{start: re}, nil
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline pattern: %w", err)
+		}
+		return &multilineMerger{start: re}, nil
+	}
+	return nil, nil
+}
+
+// strftime 形式を行頭に一致する正規表現に変換
+func strftimeToRegexp(format string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		if i+1 >= len(format) {
+			return nil, fmt.Errorf("invalid datetime format %q: trailing %%", format)
+		}
+		i++
+		p, ok := strftimePatterns[format[i]]
+		if !ok {
+			return nil, fmt.Errorf("invalid datetime format %q: unsupported %%%c", format, format[i])
+		}
+		b.WriteString(p)
+	}
+	return regexp.Compile(b.String())
+}
+
+// 古い順に並んだ同一ソースのイベントを結合する
+// 先頭行に一致しない行が最初に来た場合は、そのまま1イベントとして扱う
+func (m *multilineMerger) merge(events []TimelineEvent) []TimelineEvent {
+	if m == nil || len(events) == 0 {
+		return events
+	}
+
+	merged := make([]TimelineEvent, 0, len(events))
+	var lines []string
+	flush := func() {
+		if len(lines) < 2 {
+			lines = nil
+			return
+		}
+		first := merged[len(merged)-1]
+		e := NewEvent(first.Timestamp, first.Source, strings.Join(lines, "\n"))
+		// 先頭行でレベルが判定できなければ続きの行 (スタックトレース等) から推定
+		for _, l := range lines[1:] {
+			if e.Level != "" {
+				break
+			}
+			e.Level = detectLevel(l)
+		}
+		merged[len(merged)-1] = e
+		lines = nil
+	}
+
+	for _, e := range events {
+		if len(merged) == 0 || m.start.MatchString(e.Message) {
+			flush()
+			merged = append(merged, e)
+			lines = []string{e.Message}
+			continue
+		}
+		lines = append(lines, e.Message)
+	}
+	flush()
+	return merged
+}
@@ -0,0 +1,286 @@
+package ecstrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+type TaskProcessor struct {
+	ecsClient  ECSAPI
+	logsClient LogsAPI
+	cluster    string
+
+	// 進捗と警告の通知先 (nil なら通知しない)
+	Logger Logger
+	// Follow のポーリング間隔 (0 なら DefaultFollowInterval)
+	FollowInterval time.Duration
+
+	// awslogs-region ごとの CloudWatch Logs クライアント
+	mu            sync.Mutex
+	regionClients map[string]LogsAPI
+}
+
+func NewTaskProcessor(
+	ecsClient ECSAPI,
+	logsClient LogsAPI,
+	cluster string) *TaskProcessor {
+	return &TaskProcessor{
+		ecsClient:     ecsClient,
+		logsClient:    logsClient,
+		cluster:       cluster,
+		regionClients: make(map[string]LogsAPI),
+	}
+}
+
+// タスク1つ分の取得結果
+type TaskTrace struct {
+	Task           ecsTypes.Task
+	TaskDefinition *ecsTypes.TaskDefinition
+	// タスクが属するサービス (なければ空)
+	Service    string
+	Containers []ContainerSummary
+	// コンテナごとのログストリームと取得結果
+	LogStreams []*LogStream
+	// サービスイベント・TASK イベント・コンテナログ (フィルタ前)
+	Timeline *Timeline
+
+	// Follow で使う取得期間と、取得したソース
+	window  TimeWindow
+	sources []EventSource
+}
+
+func (p *TaskProcessor) logger() Logger {
+	if p.Logger == nil {
+		return nopLogger{}
+	}
+	return p.Logger
+}
+
+// タスクのログ・サービスイベント・ライフサイクルなど、登録済みのソースから取得し、1つの Timeline にまとめる
+// taskID はタスク ID と ARN のどちらでもよい。opts.Sources を指定すればそのソースのみ取得する
+// 一部のコンテナのログ取得に失敗しても、その理由を LogStream.Err に残して他の結果を返す
+func (p *TaskProcessor) TraceTask(ctx context.Context, taskID string, opts FetchOptions) (*TaskTrace, error) {
+	descOut, err := p.getTaskDetails(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tasks: %w", err)
+	}
+	if len(descOut.Tasks) == 0 {
+		return nil, fmt.Errorf("task not found: %s", taskID)
+	}
+
+	trace := &TaskTrace{Task: descOut.Tasks[0], Timeline: &Timeline{}, window: opts.Window}
+	if groupStr := aws.ToString(trace.Task.Group); strings.HasPrefix(groupStr, "service:") {
+		trace.Service = strings.TrimPrefix(groupStr, "service:")
+	}
+
+	// タスク定義取得
+	defOut, err := p.getTaskDefinition(ctx, trace.Task.TaskDefinitionArn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe task definition: %w", err)
+	}
+	trace.TaskDefinition = defOut.TaskDefinition
+	trace.Containers = ContainerSummaries(trace.Task, defOut.TaskDefinition)
+
+	// サービスイベント・ライフサイクル・コンテナログなど、有効なソースから取得
+	target := SourceTarget{
+		Cluster:        p.cluster,
+		Service:        trace.Service,
+		Task:           &trace.Task,
+		TaskDefinition: defOut.TaskDefinition,
+		Fetch:          opts,
+	}
+	trace.sources = append(p.newSources(ServiceScope, target), p.newSources(TaskScope, target)...)
+	p.fetchSources(ctx, trace.sources, opts.Window, trace.Timeline)
+	trace.LogStreams = logStreams(trace.sources)
+	return trace, nil
+}
+
+// リージョンに対応する CloudWatch Logs クライアントを返す
+// 既定のリージョン以外は logsClient の設定をもとに作成し、使い回す
+// フェイクなど *cloudwatchlogs.Client 以外ではリージョンを区別しない
+func (p *TaskProcessor) logsClientFor(region string) LogsAPI {
+	base, ok := p.logsClient.(*cloudwatchlogs.Client)
+	if !ok || region == "" || region == base.Options().Region {
+		return p.logsClient
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.regionClients[region]; ok {
+		return c
+	}
+	c := cloudwatchlogs.New(base.Options(), func(o *cloudwatchlogs.Options) {
+		o.Region = region
+	})
+	p.regionClients[region] = c
+	return c
+}
+
+// ECS タスクの詳細を取得する
+func (p *TaskProcessor) getTaskDetails(
+	ctx context.Context, taskID string) (*ecs.DescribeTasksOutput, error) {
+	return p.ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+		Cluster: &p.cluster,
+		Tasks:   []string{taskID},
+	})
+}
+
+// ECS タスク定義を取得する
+func (p *TaskProcessor) getTaskDefinition(
+	ctx context.Context, taskDefArn *string) (*ecs.DescribeTaskDefinitionOutput, error) {
+	return p.ecsClient.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: taskDefArn,
+	})
+}
+
+// awslogs と、CloudWatch に送る awsfirelens のコンテナのログ
+type cloudWatchLogsSource struct {
+	processor *TaskProcessor
+	def       *ecsTypes.TaskDefinition
+	// Follow ではポーリングのたびに更新されたタスクを参照する
+	task *ecsTypes.Task
+	opts FetchOptions
+	// Fetch で取得したストリームと、その読み取り位置
+	streams []*LogStream
+}
+
+func newCloudWatchLogsSource(p *TaskProcessor, target SourceTarget) EventSource {
+	if target.Task == nil || target.TaskDefinition == nil {
+		return nil
+	}
+	return &cloudWatchLogsSource{
+		processor: p,
+		def:       target.TaskDefinition,
+		task:      target.Task,
+		opts:      target.Fetch,
+	}
+}
+
+func (s *cloudWatchLogsSource) Name() string { return LogsSourceName }
+
+// コンテナごとの失敗はエラーにせず LogStream.Err に残す
+func (s *cloudWatchLogsSource) Fetch(ctx context.Context, window TimeWindow) ([]TimelineEvent, error) {
+	opts := s.opts
+	opts.Window = window
+	timeline := &Timeline{}
+	s.streams = s.processor.processContainerLogs(ctx, s.def, *s.task, opts, timeline)
+	return timeline.events, nil
+}
+
+// タスク定義からロググループを取得し、CloudWatch Logs からログを取得
+// awslogs と、CloudWatch に送る awsfirelens のコンテナが対象
+// コンテナごとに最大 opts.Concurrency 並列で取得し、取得したストリームごとの読み取り位置を返す
+// 失敗したコンテナは LogStream.Err に理由を残し、他のコンテナの結果はそのまま使う
+func (p *TaskProcessor) processContainerLogs(
+	ctx context.Context,
+	def *ecsTypes.TaskDefinition,
+	task ecsTypes.Task,
+	opts FetchOptions,
+	timeline *Timeline,
+) []*LogStream {
+	taskArn := aws.ToString(task.TaskArn)
+	var streams []*LogStream
+	// FireLens では複数のコンテナが同じストリームに書き込むことがある
+	seen := make(map[logDestination]bool)
+	for _, cdef := range def.ContainerDefinitions {
+		containerName := aws.ToString(cdef.Name)
+
+		dest, ok, err := containerLogDestination(cdef, taskArn)
+		if err != nil {
+			streams = append(streams, &LogStream{
+				Container: containerName,
+				Source:    containerName + opts.SourceSuffix,
+				Err:       fmt.Errorf("cannot resolve log stream: %w", err),
+			})
+			continue
+		}
+		if !ok || dest.stream != "" && seen[dest] {
+			continue
+		}
+		seen[dest] = true
+
+		stream := &LogStream{
+			Group:        dest.group,
+			Stream:       dest.stream,
+			Container:    containerName,
+			Source:       containerName + opts.SourceSuffix,
+			streamPrefix: dest.prefix,
+			taskID:       ArnToName(taskArn),
+			runtimeID:    containerRuntimeID(task, containerName),
+			client:       p.logsClientFor(dest.region),
+		}
+		stream.merger, err = containerMultilineMerger(cdef.LogConfiguration, opts)
+		if err != nil {
+			stream.Err = fmt.Errorf("invalid multiline setting: %w", err)
+		}
+		streams = append(streams, stream)
+	}
+
+	// コンテナごとに別の Timeline に取得し、最後にまとめて追加する
+	results := make([]*Timeline, len(streams))
+	sem := make(chan struct{}, max(opts.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		if stream.Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = &Timeline{}
+			stream.Err = p.fetchContainerLogs(ctx, stream, opts, results[i])
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		for _, e := range r.events {
+			timeline.Add(e)
+		}
+	}
+	return streams
+}
+
+// コンテナのログを取得する
+// ストリームが見つからなければ DescribeLogStreams で探し直す
+// それでも見つからない場合 (PENDING 中など) はエラーにせず LogStream.Missing に理由を残す
+func (p *TaskProcessor) fetchContainerLogs(ctx context.Context, stream *LogStream, opts FetchOptions, timeline *Timeline) error {
+	if stream.Stream != "" {
+		err := fetchCloudWatchLogsToTimeline(ctx, stream.client, stream, opts, timeline)
+		if !isResourceNotFound(err) {
+			return err
+		}
+		stream.Stream = ""
+	}
+
+	if err := stream.discover(ctx); err != nil || stream.Stream == "" {
+		return err
+	}
+	return fetchCloudWatchLogsToTimeline(ctx, stream.client, stream, opts, timeline)
+}
+
+// コンテナの awslogs-multiline-pattern / awslogs-datetime-format から merger を作成
+// opts.MultilinePattern が指定されていればそちらを使う
+func containerMultilineMerger(logConfig *ecsTypes.LogConfiguration, opts FetchOptions) (*multilineMerger, error) {
+	if opts.MultilinePattern != "" {
+		return newMultilineMerger(opts.MultilinePattern, "")
+	}
+	return newMultilineMerger(
+		logConfig.Options["awslogs-multiline-pattern"],
+		logConfig.Options["awslogs-datetime-format"],
+	)
+}
@@ -0,0 +1,190 @@
+package ecstrace
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// TraceService でソース名に付けるタスク ID の長さ
+const shortTaskIDLength = 8
+
+// TraceService で対象にしたタスクごとの情報
+type TaskSummary struct {
+	TaskArn    string             `json:"task_arn"`
+	LastStatus string             `json:"last_status"`
+	Containers []ContainerSummary `json:"containers,omitempty"`
+}
+
+// TraceService のオプション
+type ServiceOptions struct {
+	Fetch FetchOptions
+	// 実行中のタスクに加えて含める、直近に停止したタスクの数
+	StoppedTasks int
+	// 対象にするデプロイの ID (LatestDeployment なら最新)。空ならサービスの全タスク
+	Deployment string
+}
+
+// サービス1つ分の取得結果
+type ServiceTrace struct {
+	Service string
+	// 作成の古い順のタスク
+	Tasks     []ecsTypes.Task
+	Summaries []TaskSummary
+	// ServiceOptions.Deployment を指定した場合のデプロイの状態
+	Deployment *DeploymentSummary
+	LogStreams []*LogStream
+	// 全タスクのログとサービスイベント (フィルタ前)
+	Timeline *Timeline
+}
+
+// タスク ARN の末尾の ID を短縮
+func ShortTaskID(taskArn string) string {
+	id := ArnToName(taskArn)
+	if len(id) > shortTaskIDLength {
+		return id[:shortTaskIDLength]
+	}
+	return id
+}
+
+// サービスの実行中 (PENDING を含む) のタスクと、新しい順に stopped 件の停止済みタスクを取得
+func listServiceTasks(ctx context.Context, ecsClient ECSAPI, cluster, service string, stopped int) ([]ecsTypes.Task, error) {
+	arns, err := listServiceTaskArns(ctx, ecsClient, cluster, service, ecsTypes.DesiredStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := DescribeTasks(ctx, ecsClient, cluster, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	if stopped > 0 {
+		arns, err := listServiceTaskArns(ctx, ecsClient, cluster, service, ecsTypes.DesiredStatusStopped)
+		if err != nil {
+			return nil, err
+		}
+		stoppedTasks, err := DescribeTasks(ctx, ecsClient, cluster, arns)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(stoppedTasks, func(i, j int) bool {
+			return aws.ToTime(stoppedTasks[i].StoppedAt).After(aws.ToTime(stoppedTasks[j].StoppedAt))
+		})
+		tasks = append(tasks, stoppedTasks[:min(stopped, len(stoppedTasks))]...)
+	}
+
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no tasks found for service %s", service)
+	}
+	sortTasksByCreatedAt(tasks)
+	return tasks, nil
+}
+
+// サービスのタスク ARN を DesiredStatus ごとに取得
+func listServiceTaskArns(ctx context.Context, ecsClient ECSAPI, cluster, service string, status ecsTypes.DesiredStatus) ([]string, error) {
+	var taskArns []string
+	paginator := ecs.NewListTasksPaginator(ecsClient, &ecs.ListTasksInput{
+		Cluster:       &cluster,
+		ServiceName:   &service,
+		DesiredStatus: status,
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		taskArns = append(taskArns, out.TaskArns...)
+	}
+	return taskArns, nil
+}
+
+// 作成の古い順に並べる
+func sortTasksByCreatedAt(tasks []ecsTypes.Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return aws.ToTime(tasks[i].CreatedAt).Before(aws.ToTime(tasks[j].CreatedAt))
+	})
+}
+
+// サービスの全タスクのログとサービスイベントを1つの Timeline にまとめる
+// TaskScope のソースのソース名は "container@shortTaskID"、ServiceScope のソースは1回だけ取得する
+// opts.Deployment が指定されていれば、そのデプロイが起動したタスクに絞る
+func (p *TaskProcessor) TraceService(ctx context.Context, service string, opts ServiceOptions) (*ServiceTrace, error) {
+	trace := &ServiceTrace{Service: service, Timeline: &Timeline{}}
+	window := opts.Fetch.Window
+
+	if opts.Deployment != "" {
+		// デプロイが起動したタスク (サービスイベントはデプロイ作成以降のもの)
+		svc, err := describeService(ctx, p.ecsClient, p.cluster, service)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe service: %w", err)
+		}
+		d, err := findDeployment(svc, opts.Deployment)
+		if err != nil {
+			return nil, err
+		}
+		summary := newDeploymentSummary(svc, d)
+		trace.Deployment = &summary
+
+		trace.Tasks, err = listDeploymentTasks(ctx, p.ecsClient, p.cluster, service, d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		p.logger().Progressf("Tracing %d tasks of deployment %s", len(trace.Tasks), summary.ID)
+	} else {
+		var err error
+		trace.Tasks, err = listServiceTasks(ctx, p.ecsClient, p.cluster, service, opts.StoppedTasks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		p.logger().Progressf("Tracing %d tasks of service %s", len(trace.Tasks), service)
+	}
+
+	p.fetchSources(ctx, p.newSources(ServiceScope, SourceTarget{
+		Cluster:    p.cluster,
+		Service:    service,
+		Deployment: trace.Deployment,
+		Fetch:      opts.Fetch,
+	}), window, trace.Timeline)
+
+	// 同じタスク定義は1回だけ取得する
+	defs := make(map[string]*ecsTypes.TaskDefinition)
+	for i := range trace.Tasks {
+		task := &trace.Tasks[i]
+		taskArn := aws.ToString(task.TaskArn)
+
+		defArn := aws.ToString(task.TaskDefinitionArn)
+		def, ok := defs[defArn]
+		if !ok {
+			defOut, err := p.getTaskDefinition(ctx, task.TaskDefinitionArn)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe task definition: %w", err)
+			}
+			def = defOut.TaskDefinition
+			defs[defArn] = def
+		}
+
+		fetch := opts.Fetch
+		fetch.SourceSuffix = "@" + ShortTaskID(taskArn)
+		srcs := p.newSources(TaskScope, SourceTarget{
+			Cluster:        p.cluster,
+			Service:        service,
+			Task:           task,
+			TaskDefinition: def,
+			Deployment:     trace.Deployment,
+			Fetch:          fetch,
+		})
+		p.fetchSources(ctx, srcs, window, trace.Timeline)
+		trace.LogStreams = append(trace.LogStreams, logStreams(srcs)...)
+
+		trace.Summaries = append(trace.Summaries, TaskSummary{
+			TaskArn:    taskArn,
+			LastStatus: aws.ToString(task.LastStatus),
+			Containers: ContainerSummaries(*task, def),
+		})
+	}
+	return trace, nil
+}
@@ -0,0 +1,243 @@
+package ecstrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// 組み込みのイベントソース名 (FetchOptions.Sources / -sources で指定する)
+const (
+	ServiceSourceName = "service"
+	TaskSourceName    = "task"
+	LogsSourceName    = "logs"
+)
+
+// Timeline に追加するイベントの取得元
+// RegisterSource で登録すると TraceTask / TraceService で取得される
+type EventSource interface {
+	Name() string
+	// 期間内のイベントを取得する
+	Fetch(ctx context.Context, window TimeWindow) ([]TimelineEvent, error)
+}
+
+// Follow で新しいイベントを取得できる EventSource
+// 既出のイベントを返してもよい (Follow 側で除外する)
+type FollowSource interface {
+	EventSource
+	Follow(ctx context.Context) ([]TimelineEvent, error)
+}
+
+// EventSource を作る単位
+type SourceScope int
+
+const (
+	// タスクごとに作る
+	TaskScope SourceScope = iota
+	// サービスごとに1つ作る (TraceTask ではタスクがサービスに属する場合のみ)
+	ServiceScope
+)
+
+// EventSource を作る対象
+type SourceTarget struct {
+	Cluster string
+	// タスクが属するサービス (なければ空)
+	Service string
+	// TaskScope の場合のみ設定される
+	Task           *ecsTypes.Task
+	TaskDefinition *ecsTypes.TaskDefinition
+	// ServiceOptions.Deployment を指定した場合のデプロイ
+	Deployment *DeploymentSummary
+	// TaskScope のソースはイベントのソース名に Fetch.SourceSuffix を付ける
+	Fetch FetchOptions
+}
+
+// target の EventSource を作る。対象外なら nil を返す
+type SourceFactory func(p *TaskProcessor, target SourceTarget) EventSource
+
+type registeredSource struct {
+	name    string
+	scope   SourceScope
+	factory SourceFactory
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []registeredSource
+)
+
+func init() {
+	RegisterSource(ServiceSourceName, ServiceScope, newServiceEventsSource)
+	RegisterSource(TaskSourceName, TaskScope, newTaskEventsSource)
+	RegisterSource(LogsSourceName, TaskScope, newCloudWatchLogsSource)
+}
+
+// イベントソースを登録する。登録した順に取得する
+// 名前が空または登録済みなら panic する
+func RegisterSource(name string, scope SourceScope, factory SourceFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" || factory == nil {
+		panic("ecstrace: RegisterSource requires a name and a factory")
+	}
+	for _, r := range registry {
+		if r.name == name {
+			panic("ecstrace: RegisterSource called twice for " + name)
+		}
+	}
+	registry = append(registry, registeredSource{name: name, scope: scope, factory: factory})
+}
+
+// 登録済みのソース名 (登録順)
+func SourceNames() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, len(registry))
+	for i, r := range registry {
+		names[i] = r.name
+	}
+	return names
+}
+
+// カンマ区切りのソース名を検証して返す。空なら nil (全ソース)
+func ParseSources(s string) ([]string, error) {
+	known := SourceNames()
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !contains(known, name) {
+			return nil, fmt.Errorf("unknown event source %q (available: %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scope のソースのうち target.Fetch.Sources で有効なものを作る
+func (p *TaskProcessor) newSources(scope SourceScope, target SourceTarget) []EventSource {
+	registryMu.Lock()
+	entries := append([]registeredSource(nil), registry...)
+	registryMu.Unlock()
+
+	var srcs []EventSource
+	for _, r := range entries {
+		if r.scope != scope || len(target.Fetch.Sources) > 0 && !contains(target.Fetch.Sources, r.name) {
+			continue
+		}
+		if src := r.factory(p, target); src != nil {
+			srcs = append(srcs, src)
+		}
+	}
+	return srcs
+}
+
+// 各ソースから取得して Timeline に追加する
+// 取得に失敗したソースは警告して読み飛ばす
+func (p *TaskProcessor) fetchSources(ctx context.Context, srcs []EventSource, window TimeWindow, timeline *Timeline) {
+	for _, src := range srcs {
+		events, err := src.Fetch(ctx, window)
+		if err != nil {
+			p.logger().Warnf("failed to fetch %s events: %v", src.Name(), err)
+		}
+		for _, e := range events {
+			timeline.Add(e)
+		}
+	}
+}
+
+// ソースのうち CloudWatch Logs のログストリーム
+func logStreams(srcs []EventSource) []*LogStream {
+	var streams []*LogStream
+	for _, src := range srcs {
+		if s, ok := src.(*cloudWatchLogsSource); ok {
+			streams = append(streams, s.streams...)
+		}
+	}
+	return streams
+}
+
+// サービスイベント (ServiceOptions.Deployment の指定があればデプロイ作成以降のもの)
+type serviceEventsSource struct {
+	ecsClient  ECSAPI
+	cluster    string
+	service    string
+	deployment *DeploymentSummary
+	// Follow で使う取得期間
+	window TimeWindow
+}
+
+func newServiceEventsSource(p *TaskProcessor, target SourceTarget) EventSource {
+	if target.Service == "" {
+		return nil
+	}
+	return &serviceEventsSource{
+		ecsClient:  p.ecsClient,
+		cluster:    target.Cluster,
+		service:    target.Service,
+		deployment: target.Deployment,
+	}
+}
+
+func (s *serviceEventsSource) Name() string { return ServiceSourceName }
+
+func (s *serviceEventsSource) Fetch(ctx context.Context, window TimeWindow) ([]TimelineEvent, error) {
+	s.window = window
+	events, err := DescribeServiceEvents(ctx, s.ecsClient, s.cluster, s.service, window)
+	if err != nil {
+		return nil, err
+	}
+	if s.deployment != nil {
+		events = deploymentServiceEvents(events, *s.deployment)
+	}
+	return events, nil
+}
+
+func (s *serviceEventsSource) Follow(ctx context.Context) ([]TimelineEvent, error) {
+	return s.Fetch(ctx, s.window)
+}
+
+// タスクのライフサイクル (作成・イメージ取得・起動・停止) の TASK イベント
+type taskEventsSource struct {
+	// Follow ではポーリングのたびに更新されたタスクを参照する
+	task   *ecsTypes.Task
+	suffix string
+}
+
+func newTaskEventsSource(p *TaskProcessor, target SourceTarget) EventSource {
+	if target.Task == nil {
+		return nil
+	}
+	return &taskEventsSource{task: target.Task, suffix: target.Fetch.SourceSuffix}
+}
+
+func (s *taskEventsSource) Name() string { return TaskSourceName }
+
+func (s *taskEventsSource) Fetch(ctx context.Context, window TimeWindow) ([]TimelineEvent, error) {
+	var events []TimelineEvent
+	for _, e := range TaskLifecycleEvents(*s.task) {
+		if window.Contains(e.Timestamp) {
+			e.Source += s.suffix
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *taskEventsSource) Follow(ctx context.Context) ([]TimelineEvent, error) {
+	return s.Fetch(ctx, TimeWindow{})
+}
@@ -0,0 +1,128 @@
+package ecstrace
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// JSON ログで本文・レベル等を表すキーの候補 (先にあるものを優先)
+var (
+	messageKeys = []string{"message", "msg", "@message", "log", "text"}
+	levelKeys   = []string{"level", "lvl", "severity", "log.level", "levelname", "loglevel", "@level"}
+	loggerKeys  = []string{"logger", "logger_name", "loggerName", "log.logger"}
+	traceIDKeys = []string{"trace_id", "traceId", "traceID", "trace.id", "dd.trace_id"}
+	// タイムスタンプは TimelineEvent が持っているので追加項目から外す
+	timestampKeys = []string{"time", "timestamp", "@timestamp", "ts"}
+)
+
+// JSON ログから抽出した項目
+type StructuredLog struct {
+	Level   string
+	Body    string
+	Logger  string
+	TraceID string
+	// 上記以外のキー
+	Fields map[string]string
+	// JSON として解析できたか
+	Structured bool
+}
+
+// メッセージが JSON オブジェクトなら項目を抽出する
+// JSON でなければ ok=false を返す
+func parseStructuredLog(msg string) (StructuredLog, bool) {
+	trimmed := strings.TrimSpace(msg)
+	if !strings.HasPrefix(trimmed, "{") {
+		return StructuredLog{}, false
+	}
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &raw); err != nil {
+		return StructuredLog{}, false
+	}
+
+	fields := make(map[string]string)
+	flattenJSON("", raw, fields)
+
+	sl := StructuredLog{
+		Body:    takeField(fields, messageKeys),
+		Level:   normalizeLevel(takeField(fields, levelKeys)),
+		Logger:  takeField(fields, loggerKeys),
+		TraceID: takeField(fields, traceIDKeys),
+
+		Structured: true,
+	}
+	takeField(fields, timestampKeys)
+	sl.Fields = fields
+	return sl, true
+}
+
+// ネストしたオブジェクトを "a.b" 形式のキーに展開する
+func flattenJSON(prefix string, v map[string]any, out map[string]string) {
+	for k, val := range v {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch tv := val.(type) {
+		case map[string]any:
+			flattenJSON(key, tv, out)
+		case string:
+			out[key] = tv
+		case nil:
+			out[key] = ""
+		default:
+			b, _ := json.Marshal(tv)
+			out[key] = string(b)
+		}
+	}
+}
+
+// 候補キーのうち最初に見つかった値を取り出し、候補キーはすべて fields から取り除く
+func takeField(fields map[string]string, keys []string) string {
+	var value string
+	found := false
+	for _, k := range keys {
+		if v, ok := fields[k]; ok {
+			if !found {
+				value = v
+				found = true
+			}
+			delete(fields, k)
+		}
+	}
+	return value
+}
+
+// レベル表記を ERROR / WARN / INFO などに揃える
+func normalizeLevel(level string) string {
+	switch strings.ToUpper(strings.TrimSpace(level)) {
+	case "":
+		return ""
+	case "FATAL", "CRITICAL", "CRIT", "EMERG", "EMERGENCY", "ALERT", "PANIC", "60":
+		return "FATAL"
+	case "ERROR", "ERR", "50":
+		return "ERROR"
+	case "WARN", "WARNING", "40":
+		return "WARN"
+	case "INFO", "INFORMATION", "NOTICE", "30":
+		return "INFO"
+	case "DEBUG", "DBG", "20":
+		return "DEBUG"
+	case "TRACE", "TRC", "10":
+		return "TRACE"
+	default:
+		return strings.ToUpper(level)
+	}
+}
+
+// 項目の値 ("level" / "logger" / "trace_id" 以外は Fields から探す)
+func (e TimelineEvent) Field(key string) string {
+	switch key {
+	case "level":
+		return e.Level
+	case "logger":
+		return e.Logger
+	case "trace_id":
+		return e.TraceID
+	}
+	return e.Fields[key]
+}
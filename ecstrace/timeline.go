@@ -0,0 +1,117 @@
+package ecstrace
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// サービスイベント・TASK イベント・コンテナログを時刻付きでまとめたもの
+type Timeline struct {
+	events []TimelineEvent
+	// Follow で重複を除外するための既出イベント
+	seen map[string]struct{}
+	// フィルタで除外したイベント数
+	filtered int
+}
+
+type TimelineEvent struct {
+	Timestamp time.Time
+	Source    string
+	Message   string
+	// JSON ログから抽出した項目 (JSON でなければ空)
+	StructuredLog
+}
+
+// Timeline にイベントを追加
+func (tl *Timeline) Add(evt TimelineEvent) {
+	tl.events = append(tl.events, evt)
+	if tl.seen != nil {
+		tl.seen[evt.Key()] = struct{}{}
+	}
+}
+
+// 既出でなければイベントを追加し、追加したかどうかを返す
+func (tl *Timeline) AddUnique(evt TimelineEvent) bool {
+	tl.initSeen()
+	if _, ok := tl.seen[evt.Key()]; ok {
+		return false
+	}
+	tl.Add(evt)
+	return true
+}
+
+// 既出イベントの記録を開始する
+func (tl *Timeline) initSeen() {
+	if tl.seen != nil {
+		return
+	}
+	tl.seen = make(map[string]struct{}, len(tl.events))
+	for _, e := range tl.events {
+		tl.seen[e.Key()] = struct{}{}
+	}
+}
+
+// フィルタに一致しないイベントを取り除き、除外した件数を記録する
+// 除外したイベントも既出として扱い、Follow で再度数えないようにする
+func (tl *Timeline) ApplyFilter(f *Filter) {
+	if f == nil {
+		return
+	}
+	tl.initSeen()
+	kept := tl.events[:0]
+	for _, e := range tl.events {
+		if f.Match(e) {
+			kept = append(kept, e)
+		} else {
+			tl.filtered++
+		}
+	}
+	tl.events = kept
+}
+
+// 追加した順のイベント
+func (tl *Timeline) Events() []TimelineEvent {
+	return tl.events
+}
+
+// ApplyFilter で除外したイベント数
+func (tl *Timeline) Filtered() int {
+	return tl.filtered
+}
+
+// 重複判定用のキー
+func (e TimelineEvent) Key() string {
+	return fmt.Sprintf("%d|%s|%s", e.Timestamp.UnixNano(), e.Source, e.Message)
+}
+
+// イベントを古い順に並べ替えたコピーを返す
+func SortedAscending(events []TimelineEvent) []TimelineEvent {
+	sorted := make([]TimelineEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	return sorted
+}
+
+// TimelineEvent を作る簡易ヘルパー
+// JSON ログであれば項目も抽出し、レベルがなければ本文から推定する
+func NewEvent(ts time.Time, source, msg string) TimelineEvent {
+	e := TimelineEvent{
+		Timestamp: ts,
+		Source:    source,
+		Message:   msg,
+	}
+	if sl, ok := parseStructuredLog(msg); ok {
+		e.StructuredLog = sl
+	}
+	if e.Level == "" {
+		body := e.Body
+		if body == "" {
+			body = msg
+		}
+		e.Level = detectLevel(body)
+	}
+	return e
+}
@@ -0,0 +1,398 @@
+package ecstrace_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+
+	"logs-ecstask/ecstrace"
+	"logs-ecstask/ecstrace/ecstracetest"
+)
+
+// Progressf / Warnf を記録する Logger
+type recordLogger struct {
+	progress []string
+	warnings []string
+}
+
+func (l *recordLogger) Progressf(format string, args ...any) {
+	l.progress = append(l.progress, fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Warnf(format string, args ...any) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+// -----------------------------------------------------------------------------
+// TraceTask のテストです。
+// テスト内容:
+// 1. コンテナログ・サービスイベント・TASK イベントが1つの Timeline にまとめられること
+// 2. ストリームがまだないコンテナは LogStream.Missing に理由が残ること
+// 3. サービスイベントの取得に失敗しても Logger に警告して続行すること
+// 4. Logger が nil でも動作すること
+// 5. FetchOptions.Sources で指定したソースのみ取得すること
+// -----------------------------------------------------------------------------
+func TestTraceTask(t *testing.T) {
+	ctx := context.Background()
+	opts := ecstrace.FetchOptions{Limit: ecstrace.DefaultLogLimit, Concurrency: ecstrace.DefaultFetchConcurrency}
+
+	f := ecstracetest.NewWebFixture()
+	processor := ecstrace.NewTaskProcessor(f, f, ecstracetest.Cluster)
+	trace, err := processor.TraceTask(ctx, ecstracetest.TaskID, opts)
+	if err != nil {
+		t.Fatalf("TraceTask() error: %v", err)
+	}
+	if trace.Service != "web" || len(trace.Containers) != 3 {
+		t.Errorf("service = %q, containers = %d", trace.Service, len(trace.Containers))
+	}
+
+	var got []string
+	for _, e := range ecstrace.SortedAscending(trace.Timeline.Events()) {
+		got = append(got, e.Source+"\t"+e.Message)
+	}
+	want := []string{
+		"TASK\tTask created",
+		"SERVICE\t(service web) has started 1 tasks.",
+		"TASK\tTask started",
+		"sidecar\tproxy ready",
+		"app\tserver started",
+		"app\t{\"level\":\"error\",\"msg\":\"db timeout\"}",
+		"app\tGET /health 200",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	if len(trace.LogStreams) != 3 {
+		t.Fatalf("expected 3 log streams, got %d", len(trace.LogStreams))
+	}
+	if s := trace.LogStreams[0]; s.Source != "app" || s.Read != 3 || s.Err != nil {
+		t.Errorf("app stream = %+v", s)
+	}
+	if s := trace.LogStreams[1]; s.Stream != ecstracetest.SidecarRuntimeID || s.Read != 1 {
+		t.Errorf("sidecar stream = %+v", s)
+	}
+	if s := trace.LogStreams[2]; !errors.Is(s.Missing, ecstrace.ErrLogStreamNotFound) {
+		t.Errorf("worker stream missing = %v, want ErrLogStreamNotFound", s.Missing)
+	}
+
+	f = ecstracetest.NewWebFixture()
+	f.RemoveService("web")
+	logger := &recordLogger{}
+	processor = ecstrace.NewTaskProcessor(f, f, ecstracetest.Cluster)
+	processor.Logger = logger
+	if _, err := processor.TraceTask(ctx, ecstracetest.TaskArn, opts); err != nil {
+		t.Fatalf("TraceTask() error: %v", err)
+	}
+	if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], "failed to fetch service events") {
+		t.Errorf("warnings = %v", logger.warnings)
+	}
+
+	opts.Sources = []string{ecstrace.TaskSourceName}
+	trace, err = processor.TraceTask(ctx, ecstracetest.TaskID, opts)
+	if err != nil {
+		t.Fatalf("TraceTask() error: %v", err)
+	}
+	for _, e := range trace.Timeline.Events() {
+		if e.Source != ecstrace.TaskSource {
+			t.Errorf("unexpected event from %s: %s", e.Source, e.Message)
+		}
+	}
+	if len(trace.Timeline.Events()) != 2 || len(trace.LogStreams) != 0 {
+		t.Errorf("events = %d, log streams = %d; want 2, 0", len(trace.Timeline.Events()), len(trace.LogStreams))
+	}
+
+	if _, err := processor.TraceTask(ctx, "unknown", opts); err == nil {
+		t.Error("TraceTask() should fail for an unknown task")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// TraceService のテストです。
+// ソースが "container@shortTaskID" になり、進捗が Logger に通知されることを確認します。
+// -----------------------------------------------------------------------------
+func TestTraceService(t *testing.T) {
+	f := ecstracetest.NewWebFixture()
+	logger := &recordLogger{}
+	processor := ecstrace.NewTaskProcessor(f, f, ecstracetest.Cluster)
+	processor.Logger = logger
+
+	trace, err := processor.TraceService(context.Background(), "web", ecstrace.ServiceOptions{
+		Fetch: ecstrace.FetchOptions{Limit: ecstrace.DefaultLogLimit},
+	})
+	if err != nil {
+		t.Fatalf("TraceService() error: %v", err)
+	}
+	if len(trace.Tasks) != 1 || len(trace.Summaries) != 1 {
+		t.Fatalf("tasks = %d, summaries = %d; want 1", len(trace.Tasks), len(trace.Summaries))
+	}
+
+	sources := make(map[string]int)
+	for _, e := range trace.Timeline.Events() {
+		sources[e.Source]++
+	}
+	if sources["app@01234567"] != 3 || sources["sidecar@01234567"] != 1 || sources["TASK@01234567"] != 2 || sources["SERVICE"] != 1 {
+		t.Errorf("sources = %v", sources)
+	}
+	if len(logger.progress) != 1 || logger.progress[0] != "Tracing 1 tasks of service web" {
+		t.Errorf("progress = %v", logger.progress)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Follow をフェイクの AWS に対して実行するテストです。
+// テスト内容:
+// 1. 各ストリームは前回の NextForwardToken から読み、新しいログだけを返すこと
+// 2. ポーリングのたびに取得し直すサービスイベント・TASK イベントは重複して返さないこと
+// 3. TraceTask の時点でなかったストリームを途中で見つけて読み始めること
+// 4. タスクが STOPPED になったら最後に一度取得して終了すること
+// 5. ctx がキャンセルされたら終了し、fn のエラーはそのまま返すこと
+// -----------------------------------------------------------------------------
+func TestFollow(t *testing.T) {
+	ctx := context.Background()
+	opts := ecstrace.FetchOptions{Limit: ecstrace.DefaultLogLimit, Concurrency: ecstrace.DefaultFetchConcurrency}
+
+	f := ecstracetest.NewWebFixture()
+	logger := &recordLogger{}
+	processor := ecstrace.NewTaskProcessor(f, f, ecstracetest.Cluster)
+	processor.Logger = logger
+	processor.FollowInterval = time.Millisecond
+	trace, err := processor.TraceTask(ctx, ecstracetest.TaskID, opts)
+	if err != nil {
+		t.Fatalf("TraceTask() error: %v", err)
+	}
+
+	f.LogEventsRequests = nil
+	var polls [][]string
+	var appTokens []string
+	err = processor.Follow(ctx, trace, func(events []ecstrace.TimelineEvent) error {
+		var got []string
+		for _, e := range ecstrace.SortedAscending(events) {
+			got = append(got, e.Source+"\t"+e.Message)
+		}
+		polls = append(polls, got)
+		for _, req := range f.LogEventsRequests {
+			if aws.ToString(req.LogStreamName) == "ecs/app/"+ecstracetest.TaskID {
+				appTokens = append(appTokens, aws.ToString(req.NextToken))
+			}
+		}
+		f.LogEventsRequests = nil
+
+		switch len(polls) {
+		case 1:
+			f.AddLogs("/ecs/web", "ecs/app/"+ecstracetest.TaskID,
+				[]int64{ecstracetest.Millis(30 * time.Second)}, []string{"GET /ready 200"})
+			f.AddLogs("/ecs/worker", "ecs/worker/"+ecstracetest.TaskID,
+				[]int64{ecstracetest.Millis(31 * time.Second)}, []string{"worker started"})
+		case 2:
+			task := ecstracetest.NewWebTask(ecstracetest.TaskArn)
+			task.LastStatus = aws.String("STOPPED")
+			task.StoppedAt = aws.Time(ecstracetest.Base.Add(40 * time.Second))
+			f.AddTask(task)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Follow() error: %v", err)
+	}
+
+	want := [][]string{
+		nil,
+		{"app\tGET /ready 200", "worker\tworker started"},
+		{"TASK\tTask stopped"},
+	}
+	if fmt.Sprint(polls) != fmt.Sprint(want) {
+		t.Errorf("polls = %q, want %q", polls, want)
+	}
+	// TraceTask は末尾から3件読んだので、Follow はその後ろから読む
+	if strings.Join(appTokens, ",") != "f:3,f:3,f:4,f:4" {
+		t.Errorf("app stream tokens = %v", appTokens)
+	}
+	if !contains(logger.progress, "worker: found log stream ecs/worker/"+ecstracetest.TaskID) ||
+		!contains(logger.progress, "Task reached STOPPED.") {
+		t.Errorf("progress = %v", logger.progress)
+	}
+
+	trace, err = processor.TraceTask(ctx, ecstracetest.TaskID, opts)
+	if err != nil {
+		t.Fatalf("TraceTask() error: %v", err)
+	}
+	// 停止済みのタスクは最初のポーリングで終了する
+	calls := 0
+	if err := processor.Follow(ctx, trace, func([]ecstrace.TimelineEvent) error { calls++; return nil }); err != nil || calls != 1 {
+		t.Errorf("Follow() on a stopped task = %v after %d polls, want nil after 1", err, calls)
+	}
+
+	f.AddTask(ecstracetest.NewWebTask(ecstracetest.TaskArn))
+	cctx, cancel := context.WithCancel(ctx)
+	calls = 0
+	err = processor.Follow(cctx, trace, func([]ecstrace.TimelineEvent) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("Follow() after cancel = %v after %d polls, want nil after 1", err, calls)
+	}
+
+	errStop := errors.New("stop")
+	err = processor.Follow(ctx, trace, func([]ecstrace.TimelineEvent) error { return errStop })
+	if !errors.Is(err, errStop) {
+		t.Errorf("Follow() error = %v, want %v", err, errStop)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// 期間指定で Limit により打ち切った後の Follow のテストです。
+// 先頭から読んで打ち切った残りのログを新着として返さず、
+// その後に書き込まれたログだけを返すことを確認します。
+// -----------------------------------------------------------------------------
+func TestFollowAfterTruncatedWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := ecstracetest.NewWebFixture()
+	processor := ecstrace.NewTaskProcessor(f, f, ecstracetest.Cluster)
+	processor.FollowInterval = time.Millisecond
+	trace, err := processor.TraceTask(ctx, ecstracetest.TaskID, ecstrace.FetchOptions{
+		Window: ecstrace.TimeWindow{Start: ecstracetest.Base},
+		Limit:  1,
+	})
+	if err != nil {
+		t.Fatalf("TraceTask() error: %v", err)
+	}
+	if s := trace.LogStreams[0]; s.Read != 1 || !s.Truncated {
+		t.Fatalf("app stream = %+v, want 1 read and truncated", s)
+	}
+
+	var polls [][]string
+	err = processor.Follow(ctx, trace, func(events []ecstrace.TimelineEvent) error {
+		var got []string
+		for _, e := range events {
+			if e.Source == "app" {
+				got = append(got, e.Message)
+			}
+		}
+		polls = append(polls, got)
+		if len(polls) == 1 {
+			f.AddLogs("/ecs/web", "ecs/app/"+ecstracetest.TaskID,
+				[]int64{ecstracetest.Millis(30 * time.Second)}, []string{"GET /ready 200"})
+		} else {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Follow() error: %v", err)
+	}
+	want := [][]string{nil, {"GET /ready 200"}}
+	if fmt.Sprint(polls) != fmt.Sprint(want) {
+		t.Errorf("app events per poll = %q, want %q", polls, want)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
+// ListClusters / ListTaskArns をフェイクの AWS に対して実行するテストです。
+// -----------------------------------------------------------------------------
+func TestListClustersAndTasks(t *testing.T) {
+	ctx := context.Background()
+	f := ecstracetest.NewWebFixture()
+	f.Clusters = []string{"b-cluster", ecstracetest.Cluster}
+
+	clusters, err := ecstrace.ListClusters(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 2 || clusters[0] != "b-cluster" || clusters[1] != ecstracetest.Cluster {
+		t.Errorf("ListClusters() = %v", clusters)
+	}
+
+	arns, err := ecstrace.ListTaskArns(ctx, f, ecstracetest.Cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arns) != 1 || arns[0] != ecstracetest.TaskArn {
+		t.Errorf("ListTaskArns() = %v", arns)
+	}
+	if _, err := ecstrace.ListTaskArns(ctx, f, "empty-cluster"); err == nil {
+		t.Error("ListTaskArns() should fail for a cluster without tasks")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// QueryTask をフェイクの AWS に対して実行するテストです。
+// テスト内容:
+// 1. タスク定義のロググループに対して、タスクのストリームに絞り込んだクエリを実行すること
+// 2. @ptr を除いた列が返り、@logStream からコンテナ名のソースに変換されること
+// 3. @timestamp / @message がなければ Timeline のイベントにしないこと
+// -----------------------------------------------------------------------------
+func TestQueryTask(t *testing.T) {
+	ctx := context.Background()
+	f := ecstracetest.NewWebFixture()
+	field := func(name, value string) cwlTypes.ResultField {
+		return cwlTypes.ResultField{Field: aws.String(name), Value: aws.String(value)}
+	}
+	f.QueryResults = [][]cwlTypes.ResultField{
+		{field("@timestamp", "2024-01-01 00:00:20.000"), field("@logStream", "ecs/app/"+ecstracetest.TaskID), field("@message", "server started"), field("@ptr", "x")},
+		{field("@timestamp", "2024-01-01 00:00:21.500"), field("@logStream", "other"), field("@message", "ERROR boom"), field("@ptr", "y")},
+	}
+	processor := ecstrace.NewTaskProcessor(f, f, ecstracetest.Cluster)
+
+	result, err := processor.QueryTask(ctx, ecstracetest.TaskID, "fields @timestamp, @logStream, @message", ecstrace.TimeWindow{})
+	if err != nil {
+		t.Fatalf("QueryTask() error: %v", err)
+	}
+	if len(f.StartedQueries) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(f.StartedQueries))
+	}
+	input := f.StartedQueries[0]
+	if strings.Join(input.LogGroupNames, ",") != "/ecs/web,/ecs/sidecar,/ecs/worker" {
+		t.Errorf("log groups = %v", input.LogGroupNames)
+	}
+	wantQuery := `filter @logStream in ["ecs/app/` + ecstracetest.TaskID + `", "ecs/worker/` + ecstracetest.TaskID + `"]` +
+		` or @logStream like /` + ecstracetest.TaskID + `|` + ecstracetest.SidecarRuntimeID + `/` + "\n| fields @timestamp, @logStream, @message"
+	if aws.ToString(input.QueryString) != wantQuery {
+		t.Errorf("query = %q, want %q", aws.ToString(input.QueryString), wantQuery)
+	}
+	if aws.ToInt64(input.StartTime) != ecstracetest.Base.Unix() {
+		t.Errorf("start time = %d, want %d", aws.ToInt64(input.StartTime), ecstracetest.Base.Unix())
+	}
+
+	if strings.Join(result.Fields, ",") != "@timestamp,@logStream,@message" || len(result.Rows) != 2 {
+		t.Errorf("fields = %v, rows = %d", result.Fields, len(result.Rows))
+	}
+	if !result.HasEvents() {
+		t.Fatal("result with @timestamp and @message should have events")
+	}
+	events := result.Events()
+	if len(events) != 2 || events[0].Source != "app" || events[1].Source != ecstrace.QuerySource {
+		t.Fatalf("events = %+v", events)
+	}
+	if !events[1].Timestamp.Equal(ecstracetest.Base.Add(21500*time.Millisecond)) || events[1].Level != "ERROR" {
+		t.Errorf("event = %+v", events[1])
+	}
+
+	f.QueryResults = [][]cwlTypes.ResultField{{field("bin(1m)", "2024-01-01 00:00:00.000"), field("count(*)", "3")}}
+	result, err = processor.QueryTask(ctx, ecstracetest.TaskArn, "stats count(*) by bin(1m)", ecstrace.TimeWindow{})
+	if err != nil {
+		t.Fatalf("QueryTask() error: %v", err)
+	}
+	if result.HasEvents() {
+		t.Error("aggregated result should not have events")
+	}
+}
@@ -0,0 +1,45 @@
+package ecstrace
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ログ取得の対象期間。ゼロ値の端は無制限を表す
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// 期間が指定されているか
+func (w TimeWindow) IsSet() bool {
+	return !w.Start.IsZero() || !w.End.IsZero()
+}
+
+// 時刻が期間内か
+func (w TimeWindow) Contains(t time.Time) bool {
+	if !w.Start.IsZero() && t.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && t.After(w.End) {
+		return false
+	}
+	return true
+}
+
+// CloudWatch Logs の StartTime (エポックミリ秒)
+func (w TimeWindow) startMillis() *int64 {
+	if w.Start.IsZero() {
+		return nil
+	}
+	return aws.Int64(w.Start.UnixMilli())
+}
+
+// CloudWatch Logs の EndTime (エポックミリ秒)
+func (w TimeWindow) endMillis() *int64 {
+	if w.End.IsZero() {
+		return nil
+	}
+	return aws.Int64(w.End.UnixMilli())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"logs-ecstask/ecstrace"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+// サービスごとのエンドポイントを指定する環境変数 (AWS SDK / CLI と同じ名前)
+const (
+	ecsEndpointEnv     = "AWS_ENDPOINT_URL_ECS"
+	logsEndpointEnv    = "AWS_ENDPOINT_URL_CLOUDWATCH_LOGS"
+	genericEndpointEnv = "AWS_ENDPOINT_URL"
+)
+
+// エンドポイントを決める
+// -endpoint-url > サービスごとの環境変数 > AWS_ENDPOINT_URL の順に優先し、どれもなければ空文字 (既定のエンドポイント)
+func resolveEndpoint(flagValue, serviceEnv string) string {
+	for _, v := range []string{flagValue, os.Getenv(serviceEnv), os.Getenv(genericEndpointEnv)} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// -endpoint-url の値を検証
+func validateEndpointURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid endpoint URL: %s (e.g. http://localhost:4566)", s)
+	}
+	return nil
+}
+
+// ECS / CloudWatch Logs クライアントを作成
+// LocalStack などを使う場合は endpointURL または環境変数でエンドポイントを差し替える
+func newAWSClients(cfg aws.Config, endpointURL string) (*ecs.Client, *cloudwatchlogs.Client) {
+	ecsClient := ecs.NewFromConfig(cfg, func(o *ecs.Options) {
+		if ep := resolveEndpoint(endpointURL, ecsEndpointEnv); ep != "" {
+			o.BaseEndpoint = aws.String(ep)
+		}
+	})
+	logsClient := cloudwatchlogs.NewFromConfig(cfg, ecstrace.WithThrottleRetry, func(o *cloudwatchlogs.Options) {
+		if ep := resolveEndpoint(endpointURL, logsEndpointEnv); ep != "" {
+			o.BaseEndpoint = aws.String(ep)
+		}
+	})
+	return ecsClient, logsClient
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"logs-ecstask/ecstrace"
+)
+
+// コンテナごとの取得件数と打ち切りの有無を表示
+// ストリームが見つからなかったコンテナ・取得に失敗したコンテナはその理由を表示
+func printFetchSummary(streams []*ecstrace.LogStream, opts ecstrace.FetchOptions) {
+	for _, s := range streams {
+		if s.Err != nil {
+			fmt.Fprintln(statusOut, errorStyle.Render(fmt.Sprintf("%s: failed to fetch logs: %v", s.Source, s.Err)))
+			continue
+		}
+		if s.Stream == "" {
+			line := fmt.Sprintf("%s: no log stream yet (%v)", s.Source, s.Missing)
+			fmt.Fprintln(statusOut, aggregateStyle.Render(line))
+			continue
+		}
+		line := fmt.Sprintf("%s: %d events", s.Source, s.Read)
+		if s.Truncated {
+			line += fmt.Sprintf(" (stopped at -limit %d, use -all to read everything)", opts.Limit)
+			fmt.Fprintln(statusOut, aggregateStyle.Render(line))
+			continue
+		}
+		fmt.Fprintln(statusOut, waitStyle.Render(line))
+	}
+}
+
+// 取得に失敗したコンテナ名と理由 (なければ nil)
+func fetchErrors(streams []*ecstrace.LogStream) map[string]string {
+	var errs map[string]string
+	for _, s := range streams {
+		if s.Err == nil {
+			continue
+		}
+		if errs == nil {
+			errs = make(map[string]string)
+		}
+		errs[s.Source] = s.Err.Error()
+	}
+	return errs
+}
+
+// 取得に失敗したコンテナの一覧 (なければ空文字)
+func renderFetchErrors(streams []*ecstrace.LogStream, plain bool) string {
+	var b strings.Builder
+	for _, s := range streams {
+		if s.Err == nil {
+			continue
+		}
+		line := fmt.Sprintf("%s: failed to fetch logs: %v", s.Source, s.Err)
+		if !plain {
+			line = errorStyle.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
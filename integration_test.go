@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"logs-ecstask/ecstrace/ecstracetest"
+)
+
+// ECS / CloudWatch Logs の JSON プロトコル (X-Amz-Target) に応答するスタブサーバー
+// 実際のエンドポイントには接続せず、-endpoint-url の経路をオフラインで確認するために使う
+type stubAWSServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu sync.Mutex
+	// 受け取った X-Amz-Target の一覧
+	targets []string
+}
+
+// ECS は epoch 秒、CloudWatch Logs は epoch ミリ秒で時刻を返す
+var stubResponses = map[string]any{
+	"AmazonEC2ContainerServiceV20141113.DescribeTasks": map[string]any{
+		"tasks": []any{map[string]any{
+			"taskArn":           ecstracetest.TaskArn,
+			"taskDefinitionArn": ecstracetest.TaskDefinitionArn,
+			"group":             "service:web",
+			"lastStatus":        "RUNNING",
+			"createdAt":         float64(ecstracetest.Base.Unix()),
+			"startedAt":         float64(ecstracetest.Base.Unix() + 10),
+			"containers":        []any{map[string]any{"name": "app", "lastStatus": "RUNNING"}},
+		}},
+	},
+	"AmazonEC2ContainerServiceV20141113.DescribeServices": map[string]any{
+		"services": []any{map[string]any{
+			"serviceName": "web",
+			"events": []any{map[string]any{
+				"createdAt": float64(ecstracetest.Base.Unix() + 5),
+				"message":   "(service web) has started 1 tasks.",
+			}},
+		}},
+	},
+	"AmazonEC2ContainerServiceV20141113.DescribeTaskDefinition": map[string]any{
+		"taskDefinition": map[string]any{
+			"taskDefinitionArn": ecstracetest.TaskDefinitionArn,
+			"containerDefinitions": []any{map[string]any{
+				"name": "app",
+				"logConfiguration": map[string]any{
+					"logDriver": "awslogs",
+					"options":   map[string]string{"awslogs-group": "/ecs/web", "awslogs-stream-prefix": "ecs"},
+				},
+			}},
+		},
+	},
+}
+
+func newStubAWSServer(t *testing.T) *stubAWSServer {
+	s := &stubAWSServer{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubAWSServer) handle(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	var params map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.t.Errorf("%s: invalid request body: %v", target, err)
+	}
+
+	var resp any
+	switch target {
+	case "Logs_20140328.GetLogEvents":
+		resp = stubLogEvents(params)
+	default:
+		var ok bool
+		if resp, ok = stubResponses[target]; !ok {
+			s.t.Errorf("unexpected request: %s", target)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 最初の呼び出しでイベントを返し、トークン付きの呼び出しには同じトークンを返して終端を示す
+func stubLogEvents(params map[string]any) map[string]any {
+	if token, ok := params["nextToken"].(string); ok {
+		return map[string]any{"events": []any{}, "nextForwardToken": token, "nextBackwardToken": token}
+	}
+	if params["logGroupName"] != "/ecs/web" || params["logStreamName"] != "ecs/app/"+ecstracetest.TaskID {
+		return map[string]any{"events": []any{}, "nextForwardToken": "f/0", "nextBackwardToken": "b/0"}
+	}
+	return map[string]any{
+		"events": []any{
+			map[string]any{"timestamp": ecstracetest.Base.UnixMilli() + 20000, "message": "server started"},
+			map[string]any{"timestamp": ecstracetest.Base.UnixMilli() + 21000, "message": "GET /health 200"},
+		},
+		"nextForwardToken":  "f/2",
+		"nextBackwardToken": "b/0",
+	}
+}
+
+// サービスの API (X-Amz-Target の接頭辞) ごとの呼び出し回数
+func (s *stubAWSServer) count(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, target := range s.targets {
+		if strings.HasPrefix(target, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// スタブサーバー向けの設定 (認証情報は不要なので署名しない)
+func stubAWSConfig() aws.Config {
+	return aws.Config{
+		Region:           "ap-northeast-1",
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	}
+}
+
+// -----------------------------------------------------------------------------
+// -endpoint-url / AWS_ENDPOINT_URL_* を指定した実際の SDK クライアントで、
+// ローカルのスタブサーバーに対して runTrace を実行する統合テストです。
+// ネットワークや AWS の認証情報は不要で、go test -run TestIntegration ./... で実行できます。
+// テスト内容:
+// 1. -endpoint-url で ECS と CloudWatch Logs の両方がスタブサーバーに接続すること
+// 2. AWS_ENDPOINT_URL_ECS / AWS_ENDPOINT_URL_CLOUDWATCH_LOGS でサービスごとに接続先を分けられること
+// -----------------------------------------------------------------------------
+func TestIntegrationEndpointURL(t *testing.T) {
+	ctx := context.Background()
+	const (
+		ecsTarget  = "AmazonEC2ContainerServiceV20141113."
+		logsTarget = "Logs_20140328."
+	)
+	for _, env := range []string{ecsEndpointEnv, logsEndpointEnv, genericEndpointEnv} {
+		t.Setenv(env, "")
+	}
+
+	assertOutput := func(t *testing.T, data string) {
+		t.Helper()
+		for _, want := range []string{
+			"Task ARN: " + ecstracetest.TaskArn,
+			"app\tserver started",
+			"app\tGET /health 200",
+			"SERVICE\t(service web) has started 1 tasks.",
+		} {
+			if !strings.Contains(data, want) {
+				t.Errorf("output does not contain %q:\n%s", want, data)
+			}
+		}
+	}
+
+	t.Run("endpoint-url flag", func(t *testing.T) {
+		stub := newStubAWSServer(t)
+		ecsClient, logsClient := newAWSClients(stubAWSConfig(), stub.URL)
+
+		data, _ := captureOutput(t, func() error {
+			return runTrace(ctx, ecsClient, logsClient, ecstracetest.Cluster, ecstracetest.TaskID, testTraceOptions(formatText))
+		})
+		assertOutput(t, data)
+		if stub.count(ecsTarget) == 0 || stub.count(logsTarget) == 0 {
+			t.Errorf("stub received %v, want both ECS and CloudWatch Logs requests", stub.targets)
+		}
+	})
+
+	t.Run("per-service environment variables", func(t *testing.T) {
+		ecsStub := newStubAWSServer(t)
+		logsStub := newStubAWSServer(t)
+		t.Setenv(ecsEndpointEnv, ecsStub.URL)
+		t.Setenv(logsEndpointEnv, logsStub.URL)
+		ecsClient, logsClient := newAWSClients(stubAWSConfig(), "")
+
+		data, _ := captureOutput(t, func() error {
+			return runTrace(ctx, ecsClient, logsClient, ecstracetest.Cluster, ecstracetest.TaskID, testTraceOptions(formatText))
+		})
+		assertOutput(t, data)
+		if ecsStub.count(ecsTarget) == 0 || ecsStub.count(logsTarget) != 0 {
+			t.Errorf("ECS stub received %v", ecsStub.targets)
+		}
+		if logsStub.count(logsTarget) == 0 || logsStub.count(ecsTarget) != 0 {
+			t.Errorf("CloudWatch Logs stub received %v", logsStub.targets)
+		}
+	})
+}
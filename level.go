@@ -0,0 +1,24 @@
+package main
+
+import "github.com/charmbracelet/lipgloss"
+
+// レベルに応じた行の色 (INFO やレベルなしは既定の色)
+func levelColor(level string) (lipgloss.Color, bool) {
+	switch level {
+	case "FATAL", "ERROR":
+		return lipgloss.Color("#ff5f5f"), true
+	case "WARN":
+		return lipgloss.Color("#ffd700"), true
+	case "DEBUG", "TRACE":
+		return lipgloss.Color("#808080"), true
+	}
+	return "", false
+}
+
+// レベルに合わせて色を変えたスタイルを返す
+func styleForLevel(base lipgloss.Style, level string) lipgloss.Style {
+	if c, ok := levelColor(level); ok {
+		return base.Foreground(c)
+	}
+	return base
+}
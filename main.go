@@ -5,49 +5,153 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+
+	"logs-ecstask/ecstrace"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
-	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/charmbracelet/lipgloss"
 )
 
 // コマンドラインオプション
 var (
 	profile = flag.String("profile", "", "Use a specific AWS CLI profile")
+	region  = flag.String("region", "", "AWS region of the ECS cluster (overrides the profile/environment region)")
+	// LocalStack などの互換サーバーを使う場合に指定する
+	endpointURL = flag.String("endpoint-url", "", "Custom endpoint URL for ECS and CloudWatch Logs (e.g. http://localhost:4566)")
 	// 明示的に cluster / task を渡したい場合に備える（渡されなければ対話式）
 	clusterInput = flag.String("cluster", "", "ECS Cluster name/ARN")
 	taskInput    = flag.String("task", "", "ECS Task ID or ARN")
+	serviceInput = flag.String("service", "", "ECS service name: trace all of its running tasks instead of one task")
+	stopped      = flag.Int("stopped", 0, "With -service, also trace the N most recently stopped tasks")
+	deployment   = flag.String("deployment", "", "With -service, trace only the tasks of this deployment ID (or latest)")
+	follow       = flag.Bool("follow", false, "Keep streaming new logs and service events until the task stops")
+	output       = flag.String("output", "text", "Output format: text, json, ndjson or csv")
+	noPager      = flag.Bool("no-pager", false, "Print the whole timeline as plain text without colors or paging")
+	since        = flag.String("since", "", "Show events after this time (RFC3339 or relative like 15m)")
+	until        = flag.String("until", "", "Show events before this time (RFC3339 or relative like 15m)")
+	around       = flag.String("around", "", "Show events around this time (RFC3339 or relative), see -window")
+	window       = flag.Duration("window", 5*time.Minute, "Range before and after -around")
+	limit        = flag.Int("limit", ecstrace.DefaultLogLimit, "Maximum number of log events to read per container")
+	all          = flag.Bool("all", false, "Read every log event in the stream (ignores -limit)")
+	concurrency  = flag.Int("concurrency", ecstrace.DefaultFetchConcurrency, "Number of containers whose logs are fetched at the same time")
+	grep         = flag.String("grep", "", "Only show events whose message matches this regex")
+	exclude      = flag.String("exclude", "", "Hide events whose message matches this regex")
+	ignoreCase   = flag.Bool("ignore-case", false, "Case-insensitive matching for -grep and -exclude")
+	sourceFilter = flag.String("source", "", "Comma-separated sources to show (container names or SERVICE)")
+	multiline    = flag.String("multiline-pattern", "", "Regex matching the first line of a multi-line event (overrides awslogs-multiline-pattern)")
+	level        = flag.String("level", "", "Hide events below this level: trace, debug, info, warn, error or fatal")
+	fields       = flag.String("fields", "", "Comma-separated JSON log keys to show as columns (e.g. request_id,status)")
+	diagnose     = flag.Bool("diagnose", false, "Explain why a stopped task stopped and show the evidence events")
+	query        = flag.String("query", "", "Run a CloudWatch Logs Insights query over the task's log streams (e.g. 'stats count(*) by bin(1m)')")
+	sources      = flag.String("sources", "", "Comma-separated event sources to collect (default all): "+strings.Join(ecstrace.SourceNames(), ", "))
 )
 
+// runTrace の動作オプション
+type traceOptions struct {
+	follow   bool
+	format   outputFormat
+	noPager  bool
+	diagnose bool
+	// -follow のポーリング間隔 (0 なら ecstrace.DefaultFollowInterval)
+	followInterval time.Duration
+	// -service で含める停止済みタスクの数
+	stoppedTasks int
+	// -service で対象にするデプロイの ID ("latest" なら最新)
+	deployment string
+	fetch      ecstrace.FetchOptions
+	filter     *ecstrace.Filter
+}
+
 // スタイル定義
 var (
 	doneStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#00ff00"))
+			Foreground(lipgloss.Color("#00ff00"))
+
+	followStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#808080"))
 )
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 
-	var cfg aws.Config
-	var err error
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tw, err := buildTimeWindow(*since, *until, *around, *window, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter, err := ecstrace.NewFilter(*grep, *exclude, *sourceFilter, *level, *ignoreCase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range strings.Split(*fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			displayFields = append(displayFields, f)
+		}
+	}
+	if err := ecstrace.ValidateMultilinePattern(*multiline); err != nil {
+		log.Fatal(err)
+	}
+	enabledSources, err := ecstrace.ParseSources(*sources)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *diagnose && (*follow || (format != formatText && format != formatJSON)) {
+		log.Fatal("-diagnose supports only -output text or json and cannot be combined with -follow")
+	}
+	if *serviceInput != "" && (*taskInput != "" || *follow || *diagnose) {
+		log.Fatal("-service cannot be combined with -task, -follow or -diagnose")
+	}
+	if *query != "" && (*serviceInput != "" || *follow || *diagnose) {
+		log.Fatal("-query cannot be combined with -service, -follow or -diagnose")
+	}
+	if *deployment != "" && *serviceInput == "" {
+		log.Fatal("-deployment requires -service")
+	}
+	if *stopped < 0 {
+		log.Fatal("-stopped must not be negative")
+	}
+	if err := validateEndpointURL(*endpointURL); err != nil {
+		log.Fatal(err)
+	}
+	if *concurrency <= 0 {
+		log.Fatal("-concurrency must be positive")
+	}
+	if *limit <= 0 && !*all {
+		log.Fatal("-limit must be positive (use -all to read everything)")
+	}
+
+	// 機械可読形式では stdout をデータ専用にする
+	if format != formatText {
+		statusOut = os.Stderr
+	}
 
 	// AWS 設定をロード --profile が指定されていれば、その認証情報を使う
+	// --region が指定されていれば、プロファイルや環境変数のリージョンより優先する
+	var loadOpts []func(*config.LoadOptions) error
 	if *profile != "" {
-		cfg, err = config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(*profile))
-	} else {
-		cfg, err = config.LoadDefaultConfig(ctx)
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(*profile))
+	}
+	if *region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(*region))
 	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		log.Fatalf("failed to load AWS config: %v", err)
 	}
 
 	// ECS / CloudWatchLogs クライアントを初期化
-	ecsClient := ecs.NewFromConfig(cfg)
-	logsClient := cloudwatchlogs.NewFromConfig(cfg)
+	// awslogs-region が異なるコンテナのクライアントは TaskProcessor が作成する
+	ecsClient, logsClient := newAWSClients(cfg, *endpointURL)
 
 	// クラスターを選択
 	chosenCluster := *clusterInput
@@ -58,6 +162,36 @@ func main() {
 		}
 	}
 
+	// ログ + サービスイベント を一括で取得・出力
+	opts := traceOptions{
+		follow:       *follow,
+		format:       format,
+		noPager:      *noPager,
+		diagnose:     *diagnose,
+		stoppedTasks: *stopped,
+		deployment:   *deployment,
+		fetch: ecstrace.FetchOptions{
+			Window:           tw,
+			Limit:            *limit,
+			MultilinePattern: *multiline,
+			Concurrency:      *concurrency,
+			Sources:          enabledSources,
+		},
+		filter: filter,
+	}
+	if *all {
+		opts.fetch.Limit = 0
+	}
+
+	// -service ではサービスの全タスクをまとめて表示
+	if *serviceInput != "" {
+		if err := runServiceTrace(ctx, ecsClient, logsClient, chosenCluster, *serviceInput, opts); err != nil {
+			log.Fatalf("failed to trace service: %v", err)
+		}
+		fmt.Fprintln(statusOut, doneStyle.Render("Done."))
+		return
+	}
+
 	// タスクを選択
 	chosenTask := *taskInput
 	if chosenTask == "" {
@@ -67,63 +201,120 @@ func main() {
 		}
 	}
 
-	// ログ + サービスイベント を一括で取得・出力
-	err = runTrace(ctx, ecsClient, logsClient, chosenCluster, chosenTask)
+	// -query では GetLogEvents の代わりに Insights のクエリを実行
+	if *query != "" {
+		if err := runQuery(ctx, ecsClient, logsClient, chosenCluster, chosenTask, *query, opts); err != nil {
+			log.Fatalf("failed to run query: %v", err)
+		}
+		fmt.Fprintln(statusOut, doneStyle.Render("Done."))
+		return
+	}
+
+	err = runTrace(ctx, ecsClient, logsClient, chosenCluster, chosenTask, opts)
 	if err != nil {
 		log.Fatalf("failed to trace logs: %v", err)
 	}
 
-	fmt.Println(doneStyle.Render("Done."))
+	fmt.Fprintln(statusOut, doneStyle.Render("Done."))
 }
 
-// タスクのログとサービスイベントを取得し、Timeline に追加
-func runTrace(ctx context.Context, ecsClient *ecs.Client, logsClient *cloudwatchlogs.Client, cluster string, taskID string) error {
-	processor := NewTaskProcessor(ecsClient, logsClient, cluster)
-	timeline := &Timeline{}
+// 進捗をステータス出力に表示する TaskProcessor を作成
+func newProcessor(ecsClient ecstrace.ECSAPI, logsClient ecstrace.LogsAPI, cluster string) *ecstrace.TaskProcessor {
+	processor := ecstrace.NewTaskProcessor(ecsClient, logsClient, cluster)
+	processor.Logger = statusLogger{}
+	return processor
+}
 
-	// タスク情報取得
-	descOut, err := processor.getTaskDetails(ctx, taskID)
+// タスクのログとサービスイベントを取得し、出力形式に合わせて出力
+func runTrace(ctx context.Context, ecsClient ecstrace.ECSAPI, logsClient ecstrace.LogsAPI, cluster string, taskID string, opts traceOptions) error {
+	processor := newProcessor(ecsClient, logsClient, cluster)
+	processor.FollowInterval = opts.followInterval
+	trace, err := processor.TraceTask(ctx, taskID, opts.fetch)
 	if err != nil {
-		return fmt.Errorf("failed to describe tasks: %w", err)
-	}
-	if len(descOut.Tasks) == 0 {
-		return fmt.Errorf("task not found: %s", taskID)
+		return err
 	}
+	printFetchSummary(trace.LogStreams, opts.fetch)
 
-	task := descOut.Tasks[0]
+	task := trace.Task
 	taskArn := aws.ToString(task.TaskArn)
+	plain := opts.noPager || !isTerminal(os.Stdout)
+	meta := taskMetadata{
+		Cluster:    cluster,
+		TaskArn:    taskArn,
+		LastStatus: aws.ToString(task.LastStatus),
+		Containers: trace.Containers,
+		LogErrors:  fetchErrors(trace.LogStreams),
+	}
 
-	// サービスイベント取得
-	if groupStr := aws.ToString(task.Group); strings.HasPrefix(groupStr, "service:") {
-		svcName := strings.TrimPrefix(groupStr, "service:")
-		if err := fetchServiceEvents(ctx, ecsClient, cluster, svcName, timeline); err != nil {
-			log.Printf("failed to fetch service events: %v", err)
+	// -diagnose: フィルタ前の全イベントから停止原因を判定する
+	if opts.diagnose {
+		if task.StoppedAt == nil && aws.ToString(task.LastStatus) != "STOPPED" {
+			return fmt.Errorf("task has not stopped yet (last status: %s)", aws.ToString(task.LastStatus))
+		}
+		diags := diagnoseTask(diagnoseInput{task: task, containers: trace.Containers, events: trace.Timeline.Events()})
+		if opts.format == formatJSON {
+			return writeDiagnosesJSON(dataOut, task, meta, diags)
 		}
+		fmt.Fprintln(dataOut, renderTaskHeader(taskArn, aws.ToString(task.LastStatus), trace.Containers, plain)+
+			renderFetchErrors(trace.LogStreams, plain))
+		printDiagnoses(dataOut, diags, plain)
+		return nil
 	}
 
-	// タスク定義取得
-	defOut, err := processor.getTaskDefinition(ctx, task.TaskDefinitionArn)
-	if err != nil {
-		return fmt.Errorf("failed to describe task definition: %w", err)
+	// 表示前にフィルタを適用
+	trace.Timeline.ApplyFilter(opts.filter)
+
+	// 取得に失敗したコンテナはページャーでも見えるようヘッダーに含める
+	header := renderTaskHeader(taskArn, aws.ToString(task.LastStatus), trace.Containers, plain) +
+		renderFetchErrors(trace.LogStreams, plain)
+	if !opts.follow {
+		return printTimeline(trace.Timeline, header, meta, opts, plain)
 	}
 
-	// コンテナログ処理
-	if err := processor.processContainerLogs(ctx, defOut.TaskDefinition, taskArn, timeline); err != nil {
-		log.Printf("Error processing container logs: %v", err)
+	if opts.format == formatText {
+		fmt.Fprintln(dataOut, header)
+	}
+	enc := newEventEncoder(dataOut, opts.format, meta, plain)
+	if err := enc.Encode(trace.Timeline.Events()); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
 	}
 
-	taskStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("39")).
-		Bold(true)
+	// -follow: 以降は新規イベントを追記していく
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Printf("%s %s\n",
-		taskStyle.Render("Task ARN:"),
-		taskMessageStyle.Render(taskArn))
-	fmt.Printf("%s %s\n\n",
-		taskStyle.Render("Last Status:"),
-		taskMessageStyle.Render(aws.ToString(task.LastStatus)))
+	fmt.Fprintln(statusOut, followStyle.Render("Following new events... (Stop: Ctrl+C)"))
+	err = processor.Follow(ctx, trace, func(events []ecstrace.TimelineEvent) error {
+		var visible []ecstrace.TimelineEvent
+		for _, e := range events {
+			if opts.filter.Match(e) {
+				visible = append(visible, e)
+			}
+		}
+		return enc.Encode(visible)
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
 
-	timeline.Print()
+// Timeline を出力形式に合わせて出力 (-follow なし)
+// テキスト以外の形式ではタスク情報もデータとして出力する
+func printTimeline(timeline *ecstrace.Timeline, header string, meta taskMetadata, opts traceOptions, plain bool) error {
+	if opts.format == formatText {
+		// 全画面ページャーではヘッダーを画面上部に表示する
+		if plain {
+			printPlain(dataOut, timeline, header)
+		} else {
+			printPager(timeline, header)
+		}
+		return nil
+	}
 
-	return nil
+	enc := newEventEncoder(dataOut, opts.format, meta, plain)
+	if err := enc.Encode(timeline.Events()); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+	return enc.Close()
 }
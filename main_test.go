@@ -1,222 +1,1124 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"logs-ecstask/ecstrace"
+	"logs-ecstask/ecstrace/ecstracetest"
 )
 
 // -----------------------------------------------------------------------------
-// このテストでは、ecs.Client、cloudwatchlogs.Client、およびクラスター名が正しく設定されているかを確認します。
-// ecsClient、logsClient、および cluster が期待通りに設定されていない場合、エラーメッセージを出力します。
+// Timeline 構造体にイベントを追加し、それらが正しくソートされているかを確認するテストです。
+// テスト内容:
+// 1. テストデータとして2つのイベントを作成します。
+// 2. それらのイベントを Timeline に追加します。
+// 3. Timeline のイベント数が期待通りであることを確認します。
+// 4. sortedDescending で並べ替え、イベントが新しい順になっていることを確認します。
 // -----------------------------------------------------------------------------
-func TestNewTaskProcessor(t *testing.T) {
-	ecsClient := &ecs.Client{}
-	logsClient := &cloudwatchlogs.Client{}
-	cluster := "test-cluster"
+func TestTimelineAddAndPrint(t *testing.T) {
+	tl := &ecstrace.Timeline{}
 
-	processor := NewTaskProcessor(ecsClient, logsClient, cluster)
+	// テストデータ作成
+	events := []ecstrace.TimelineEvent{
+		{
+			Timestamp: time.Date(2023, 1, 2, 15, 04, 05, 0, time.UTC),
+			Source:    "TEST1",
+			Message:   "Message 1",
+		},
+		{
+			Timestamp: time.Date(2023, 1, 1, 15, 04, 05, 0, time.UTC),
+			Source:    "TEST2",
+			Message:   "Message 2",
+		},
+	}
 
-	if processor.ecsClient != ecsClient {
-		t.Error("ecsClient was not properly set")
+	// イベント追加
+	for _, e := range events {
+		tl.Add(e)
 	}
-	if processor.logsClient != logsClient {
-		t.Error("logsClient was not properly set")
+
+	// 要素数の確認
+	if len(tl.Events()) != 2 {
+		t.Errorf("expected 2 events, got %d", len(tl.Events()))
 	}
-	if processor.cluster != cluster {
-		t.Errorf("cluster = %s, want %s", processor.cluster, cluster)
+
+	// ソート後、最初のイベントが新しい方になることを確認
+	sorted := sortedDescending(tl.Events())
+	if sorted[0].Timestamp.Before(sorted[1].Timestamp) {
+		t.Error("events are not properly sorted")
 	}
 }
 
 // -----------------------------------------------------------------------------
-// isAwslogsDriver 関数の動作をテストします。
+// 全画面ページャーのキー操作をテストします。
+// テスト内容:
+// 1. j / G / g で選択位置が移動し、端で止まること
+// 2. "/" の検索入力で一致するイベントへ移動し、n で次の一致へ進むこと
+// 3. s で並び順を切り替えても選択中のイベントが維持されること
+// -----------------------------------------------------------------------------
+func TestPagerModel(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []ecstrace.TimelineEvent
+	for i, msg := range []string{"boot", "error one", "ok", "error two", "done"} {
+		events = append(events, ecstrace.NewEvent(ts.Add(-time.Duration(i)*time.Second), "app", msg))
+	}
+
+	var m tea.Model = newPagerModel(events, 0, "")
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 100, Height: 5})
+
+	press := func(keys ...string) {
+		for _, k := range keys {
+			switch k {
+			case "enter":
+				m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+			default:
+				m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+			}
+		}
+	}
+	cursor := func() int { return m.(pagerModel).cursor }
+
+	press("j", "j")
+	if cursor() != 2 {
+		t.Errorf("cursor = %d after j j, want 2", cursor())
+	}
+	press("G", "j")
+	if cursor() != 4 {
+		t.Errorf("cursor = %d after G j, want 4", cursor())
+	}
+	press("g")
+	if cursor() != 0 {
+		t.Errorf("cursor = %d after g, want 0", cursor())
+	}
+
+	press("/", "e", "r", "r", "enter")
+	if cursor() != 1 {
+		t.Errorf("cursor = %d after search, want 1", cursor())
+	}
+	press("n")
+	if cursor() != 3 {
+		t.Errorf("cursor = %d after n, want 3", cursor())
+	}
+
+	press("s")
+	pm := m.(pagerModel)
+	if pm.newestFirst {
+		t.Error("order was not toggled")
+	}
+	if pm.events[pm.cursor].Message != "error two" {
+		t.Errorf("selected = %q after toggle, want %q", pm.events[pm.cursor].Message, "error two")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// parseOutputFormat が対応形式のみを受け付けることをテストします。
+// -----------------------------------------------------------------------------
+func TestParseOutputFormat(t *testing.T) {
+	for _, s := range []string{"text", "json", "ndjson", "csv"} {
+		if _, err := parseOutputFormat(s); err != nil {
+			t.Errorf("parseOutputFormat(%s) returned error: %v", s, err)
+		}
+	}
+	if _, err := parseOutputFormat("yaml"); err == nil {
+		t.Error("parseOutputFormat(yaml) should return error")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// 機械可読形式のエンコーダが古い順に全イベントとタスク情報を出力することをテストします。
 // テストケース:
-// 1. "nil config": LogConfiguration が nil の場合
-// 2. "awslogs driver": LogDriver が ecsTypes.LogDriverAwslogs の場合 (正常系)
-// 3. "other driver": LogDriver が "json-file" の場合
+// 1. "json": events 配列とタスク情報を含む1つのドキュメント
+// 2. "ndjson": 1行1イベントでタスク ARN を含む
+// 3. "csv": ヘッダー行 + イベント行
 // -----------------------------------------------------------------------------
-func TestIsAwslogsDriver(t *testing.T) {
-	testCases := []struct {
+func TestEventEncoders(t *testing.T) {
+	meta := taskMetadata{Cluster: "c", TaskArn: "arn:task", LastStatus: "RUNNING"}
+	events := []ecstrace.TimelineEvent{
+		ecstrace.NewEvent(time.Date(2023, 1, 2, 0, 0, 0, 500, time.UTC), "app", "newer"),
+		ecstrace.NewEvent(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), "SERVICE", "older"),
+	}
+
+	tests := []struct {
 		name     string
-		config   *ecsTypes.LogConfiguration
-		expected bool
+		format   outputFormat
+		expected string
 	}{
 		{
-			name:     "nil config",
-			config:   nil,
-			expected: false,
+			name:   "json",
+			format: formatJSON,
+			expected: `{
+  "cluster": "c",
+  "task_arn": "arn:task",
+  "last_status": "RUNNING",
+  "events": [
+    {
+      "timestamp": "2023-01-01T00:00:00Z",
+      "source": "SERVICE",
+      "message": "older"
+    },
+    {
+      "timestamp": "2023-01-02T00:00:00.0000005Z",
+      "source": "app",
+      "message": "newer"
+    }
+  ]
+}
+`,
 		},
 		{
-			name: "awslogs driver",
-			config: &ecsTypes.LogConfiguration{
-				LogDriver: ecsTypes.LogDriverAwslogs,
-			},
-			expected: true,
+			name:   "ndjson",
+			format: formatNDJSON,
+			expected: `{"timestamp":"2023-01-01T00:00:00Z","source":"SERVICE","message":"older","task_arn":"arn:task","last_status":"RUNNING"}
+{"timestamp":"2023-01-02T00:00:00.0000005Z","source":"app","message":"newer","task_arn":"arn:task","last_status":"RUNNING"}
+`,
 		},
 		{
-			name: "other driver",
-			config: &ecsTypes.LogConfiguration{
-				LogDriver: "json-file",
-			},
-			expected: false,
+			name:   "csv",
+			format: formatCSV,
+			expected: `timestamp,source,message,task_arn,last_status
+2023-01-01T00:00:00Z,SERVICE,older,arn:task,RUNNING
+2023-01-02T00:00:00.0000005Z,app,newer,arn:task,RUNNING
+`,
 		},
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			got := isAwslogsDriver(tc.config)
-			if got != tc.expected {
-				t.Errorf("isAwslogsDriver() = %v, want %v", got, tc.expected)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := newEventEncoder(&buf, tt.format, meta, false)
+			if err := enc.Encode(events); err != nil {
+				t.Fatalf("Encode() error: %v", err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatalf("Close() error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tt.expected)
 			}
 		})
 	}
 }
 
 // -----------------------------------------------------------------------------
-// Timeline 構造体にイベントを追加し、それらが正しくソートされているかを確認するテストです。
-// テスト内容:
-// 1. テストデータとして2つのイベントを作成します。
-// 2. それらのイベントを Timeline に追加します。
-// 3. Timeline のイベント数が期待通りであることを確認します。
-// 4. Timeline の Print メソッドを呼び出し、イベントがタイムスタンプ順にソートされていることを確認します。
+// printPlain が色・ページングなしで全イベントを新しい順に出力することをテストします。
 // -----------------------------------------------------------------------------
-func TestTimelineAddAndPrint(t *testing.T) {
-	tl := &Timeline{}
+func TestTimelinePrintPlain(t *testing.T) {
+	tl := &ecstrace.Timeline{}
+	tl.Add(ecstrace.NewEvent(time.Date(2023, 1, 1, 15, 04, 05, 0, time.UTC), "SERVICE", "older"))
+	tl.Add(ecstrace.NewEvent(time.Date(2023, 1, 2, 15, 04, 05, 0, time.UTC), "app", "newer"))
 
-	// テストデータ作成
-	events := []TimelineEvent{
+	var buf bytes.Buffer
+	printPlain(&buf, tl, "")
+
+	expected := "2023-01-02 15:04:05\tapp\tnewer\n" +
+		"2023-01-01 15:04:05\tSERVICE\tolder\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// buildTimeWindow のテストです。
+// テストケース:
+// 1. "none": 何も指定しない場合は無制限
+// 2. "relative since": -since 15m は現在から15分前
+// 3. "absolute range": RFC3339 で -since / -until を指定
+// 4. "around": -around を中心に前後 -window の範囲
+// 5. "around with since": -around と -since の併用はエラー
+// 6. "reversed": -until が -since より前ならエラー
+// 7. "invalid": 解釈できない値はエラー
+// -----------------------------------------------------------------------------
+func TestBuildTimeWindow(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		since     string
+		until     string
+		around    string
+		window    time.Duration
+		wantStart time.Time
+		wantEnd   time.Time
+		wantErr   bool
+	}{
+		{name: "none"},
 		{
-			Timestamp: time.Date(2023, 1, 2, 15, 04, 05, 0, time.UTC),
-			Source:    "TEST1",
-			Message:   "Message 1",
+			name:      "relative since",
+			since:     "15m",
+			wantStart: now.Add(-15 * time.Minute),
 		},
 		{
-			Timestamp: time.Date(2023, 1, 1, 15, 04, 05, 0, time.UTC),
-			Source:    "TEST2",
-			Message:   "Message 2",
+			name:      "absolute range",
+			since:     "2024-01-02T10:00:00Z",
+			until:     "2024-01-02T11:00:00Z",
+			wantStart: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "around",
+			around:    "2024-01-02T10:00:00Z",
+			window:    5 * time.Minute,
+			wantStart: time.Date(2024, 1, 2, 9, 55, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 1, 2, 10, 5, 0, 0, time.UTC),
 		},
+		{name: "around with since", around: "1h", since: "2h", window: time.Minute, wantErr: true},
+		{name: "reversed", since: "1h", until: "2h", wantErr: true},
+		{name: "invalid", since: "yesterday", wantErr: true},
 	}
 
-	// イベント追加
-	for _, e := range events {
-		tl.Add(e)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildTimeWindow(tt.since, tt.until, tt.around, tt.window, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Start.Equal(tt.wantStart) || !got.End.Equal(tt.wantEnd) {
+				t.Errorf("got [%v, %v], want [%v, %v]", got.Start, got.End, tt.wantStart, tt.wantEnd)
+			}
+		})
 	}
+}
 
-	// 要素数の確認
-	if len(tl.events) != 2 {
-		t.Errorf("expected 2 events, got %d", len(tl.events))
+// -----------------------------------------------------------------------------
+// printFetchSummary がコンテナごとの件数と -limit による打ち切り、
+// ストリームが見つからなかったコンテナを表示することをテストします。
+// -----------------------------------------------------------------------------
+func TestPrintFetchSummary(t *testing.T) {
+	var buf bytes.Buffer
+	orig := statusOut
+	statusOut = &buf
+	defer func() { statusOut = orig }()
+
+	cursors := []*ecstrace.LogStream{
+		{Source: "app", Stream: "ecs/app/abc", Read: 400, Truncated: true},
+		{Source: "sidecar", Stream: "ecs/sidecar/abc", Read: 12},
+		{Source: "worker", Missing: ecstrace.ErrLogStreamNotFound},
 	}
+	printFetchSummary(cursors, ecstrace.FetchOptions{Limit: 400})
 
-	// ソート後、最初のイベントが古い方になることを確認
-	tl.Print() // ソートを実行
-	if tl.events[0].Timestamp.Before(tl.events[1].Timestamp) {
-		t.Error("events are not properly sorted")
+	got := buf.String()
+	if !strings.Contains(got, "app: 400 events (stopped at -limit 400") {
+		t.Errorf("missing truncated summary for app: %q", got)
+	}
+	if !strings.Contains(got, "sidecar: 12 events\n") {
+		t.Errorf("missing summary for sidecar: %q", got)
+	}
+	if !strings.Contains(got, "worker: no log stream yet (log stream does not exist yet)") {
+		t.Errorf("missing summary for worker: %q", got)
 	}
 }
 
 // -----------------------------------------------------------------------------
-// 正しくEvent構造体を初期化することをテストします。作成されたEventのTimestamp、Source、
-// およびMessageフィールドが期待される値と一致することを確認します。
+// fuzzyScore のテストです。
+// 入力の文字が順番どおりに含まれていれば一致とし、連続した一致ほど高いスコアになることを確認します。
 // -----------------------------------------------------------------------------
-func TestNewEvent(t *testing.T) {
-	ts := time.Now()
-	source := "TEST"
-	msg := "test message"
+func TestFuzzyScore(t *testing.T) {
+	if _, ok := fuzzyScore("", "anything"); !ok {
+		t.Error("empty query should match everything")
+	}
+	if _, ok := fuzzyScore("prd", "Production-cluster"); !ok {
+		t.Error("subsequence should match")
+	}
+	if _, ok := fuzzyScore("xyz", "production-cluster"); ok {
+		t.Error("non-subsequence should not match")
+	}
 
-	event := newEvent(ts, source, msg)
+	exact, _ := fuzzyScore("api", "api-server")
+	scattered, _ := fuzzyScore("api", "a-p-i-server")
+	if exact <= scattered {
+		t.Errorf("consecutive match score %d should be higher than scattered %d", exact, scattered)
+	}
+}
 
-	if event.Timestamp != ts {
-		t.Errorf("expected timestamp %v, got %v", ts, event.Timestamp)
+// -----------------------------------------------------------------------------
+// promptIndex が不正な入力の後も再入力を求め、正しい番号を返すことをテストします。
+// -----------------------------------------------------------------------------
+func TestPromptIndex(t *testing.T) {
+	var buf bytes.Buffer
+	orig := statusOut
+	statusOut = &buf
+	defer func() { statusOut = orig }()
+
+	displayed := 0
+	idx, err := promptIndex(strings.NewReader("abc\n9\n1\n"), 3, func() { displayed++ })
+	if err != nil {
+		t.Fatalf("promptIndex() error: %v", err)
+	}
+	if idx != 1 {
+		t.Errorf("idx = %d, want 1", idx)
 	}
-	if event.Source != source {
-		t.Errorf("expected source %s, got %s", source, event.Source)
+	if displayed != 1 {
+		t.Errorf("list displayed %d times, want 1", displayed)
 	}
-	if event.Message != msg {
-		t.Errorf("expected message %s, got %s", msg, event.Message)
+	if got := strings.Count(buf.String(), "invalid index"); got != 2 {
+		t.Errorf("invalid index shown %d times, want 2", got)
+	}
+
+	if _, err := promptIndex(strings.NewReader(""), 3, func() {}); err == nil {
+		t.Error("EOF should return error")
 	}
 }
 
 // -----------------------------------------------------------------------------
-// このテストは、以下のケースを検証します:
-// 1. タスク ARN からタスク ID を抽出するケース
-// 2. クラスター ARN からクラスター名を抽出するケース
-// 3. シンプルなパスからリソース名を抽出するケース
-// 各テストケースでは、期待される出力と実際の出力を比較し、一致しない場合はエラーメッセージを表示します。
+// containerSummaries のテストです。
+// テスト内容:
+// 1. task.Containers とタスク定義の Essential がコンテナ名で結合されること
+// 2. EssentialContainerExited の場合、0 以外で終了した essential コンテナに印が付くこと
+// 3. 表に停止原因のコンテナと短縮したイメージダイジェストが表示されること
 // -----------------------------------------------------------------------------
-func TestArnToName(t *testing.T) {
+func TestContainerSummaries(t *testing.T) {
+	task := ecsTypes.Task{
+		StopCode: ecsTypes.TaskStopCodeEssentialContainerExited,
+		Containers: []ecsTypes.Container{
+			{
+				Name:        aws.String("app"),
+				LastStatus:  aws.String("STOPPED"),
+				ExitCode:    aws.Int32(137),
+				Reason:      aws.String("OutOfMemoryError: Container killed due to memory usage"),
+				ImageDigest: aws.String("sha256:0123456789abcdef0123"),
+			},
+			{
+				Name:       aws.String("log-router"),
+				LastStatus: aws.String("STOPPED"),
+				ExitCode:   aws.Int32(1),
+			},
+		},
+	}
+	def := &ecsTypes.TaskDefinition{
+		ContainerDefinitions: []ecsTypes.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("log-router"), Essential: aws.Bool(false)},
+		},
+	}
+
+	got := ecstrace.ContainerSummaries(task, def)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(got))
+	}
+	if !got[0].Essential || got[1].Essential {
+		t.Errorf("essential = %v, %v; want true, false", got[0].Essential, got[1].Essential)
+	}
+	if !got[0].CausedStop || got[1].CausedStop {
+		t.Errorf("caused stop = %v, %v; want true, false", got[0].CausedStop, got[1].CausedStop)
+	}
+
+	table := renderContainerTable(got, true)
+	if !strings.Contains(table, "! app") {
+		t.Errorf("table does not highlight the stop cause:\n%s", table)
+	}
+	if !strings.Contains(table, "sha256:0123456789ab ") {
+		t.Errorf("table does not contain short digest:\n%s", table)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// diagnoseTask のテストです。
+// テスト内容:
+// 1. StopCode・StoppedReason・終了コード・サービスイベントから停止原因が分類されること
+// 2. 根拠として停止イベントや該当するログ・サービスイベントが含まれること
+// 3. どのルールにも該当しなければ Unknown になること
+// 4. 他のタスクについてのサービスイベントや、停止より前の登録解除は根拠にしないこと
+// 5. このタスクの ID を含むサービスイベントは、停止より前でも根拠にすること
+// -----------------------------------------------------------------------------
+func TestDiagnoseTask(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stopped := func(code ecsTypes.TaskStopCode, reason string) ecsTypes.Task {
+		return ecsTypes.Task{
+			TaskArn:       aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/aaaa1111"),
+			LastStatus:    aws.String("STOPPED"),
+			StopCode:      code,
+			StoppedReason: aws.String(reason),
+			StoppedAt:     aws.Time(base.Add(time.Minute)),
+		}
+	}
+
 	tests := []struct {
-		name     string
-		arn      string
-		expected string
+		name         string
+		task         ecsTypes.Task
+		containers   []ecstrace.ContainerSummary
+		events       []ecstrace.TimelineEvent
+		wantCategory string
+		wantEvidence string
 	}{
 		{
-			name:     "task ARN",
-			arn:      "arn:aws:ecs:region:account:task/cluster/task-id",
-			expected: "task-id",
+			name:         "OOM",
+			task:         stopped(ecsTypes.TaskStopCodeEssentialContainerExited, "Essential container in task exited"),
+			containers:   []ecstrace.ContainerSummary{{Name: "app", Essential: true, ExitCode: aws.Int32(137), CausedStop: true}},
+			wantCategory: "OOM",
+			wantEvidence: "Task stopped",
 		},
 		{
-			name:     "cluster ARN",
-			arn:      "arn:aws:ecs:region:account:cluster/cluster-name",
-			expected: "cluster-name",
+			name:         "image pull",
+			task:         stopped(ecsTypes.TaskStopCodeTaskFailedToStart, "CannotPullContainerError: pull image manifest has been retried 1 time(s)"),
+			wantCategory: "Image pull failure",
+			wantEvidence: "CannotPullContainerError",
 		},
 		{
-			name:     "simple path",
-			arn:      "path/to/resource",
-			expected: "resource",
+			name: "ELB health check",
+			task: stopped(ecsTypes.TaskStopCodeServiceSchedulerInitiated, "Task failed ELB health checks in (target-group arn:aws:elasticloadbalancing:...)"),
+			events: []ecstrace.TimelineEvent{
+				ecstrace.NewEvent(base, "SERVICE", "(service web) (port 80) is unhealthy in (target-group arn:aws:elasticloadbalancing:...) due to (reason Health checks failed)."),
+			},
+			wantCategory: "Failed health check",
+			wantEvidence: "is unhealthy in",
+		},
+		{
+			name: "ELB deregistration",
+			task: stopped(ecsTypes.TaskStopCodeServiceSchedulerInitiated, "Scaling activity initiated by (deployment ecs-svc/123)"),
+			events: []ecstrace.TimelineEvent{
+				ecstrace.NewEvent(base, "SERVICE", "(service web) has begun draining connections on 1 tasks."),
+				ecstrace.NewEvent(base.Add(time.Second), "SERVICE", "(service web) deregistered 1 targets in (target-group arn:aws:elasticloadbalancing:...)"),
+			},
+			wantCategory: "ELB target deregistration",
+			wantEvidence: "deregistered 1 targets",
+		},
+		{
+			name:         "secrets",
+			task:         stopped(ecsTypes.TaskStopCodeTaskFailedToStart, "ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: unable to retrieve secret from asm"),
+			wantCategory: "Secrets / SSM resolution error",
+			wantEvidence: "unable to retrieve secret",
+		},
+		{
+			name:       "essential exit",
+			task:       stopped(ecsTypes.TaskStopCodeEssentialContainerExited, "Essential container in task exited"),
+			containers: []ecstrace.ContainerSummary{{Name: "app", Essential: true, ExitCode: aws.Int32(1), CausedStop: true}},
+			events: []ecstrace.TimelineEvent{
+				ecstrace.NewEvent(base, "app", "starting server"),
+				ecstrace.NewEvent(base.Add(time.Second), "app", "fatal: config file not found"),
+			},
+			wantCategory: "Essential container exited",
+			wantEvidence: "config file not found",
+		},
+		{
+			name:         "spot",
+			task:         stopped(ecsTypes.TaskStopCodeSpotInterruption, "Your Spot Task was interrupted."),
+			wantCategory: "Spot interruption",
+			wantEvidence: "Task stopped",
+		},
+		{
+			name:         "unknown",
+			task:         stopped(ecsTypes.TaskStopCodeUserInitiated, "Task stopped by user"),
+			wantCategory: "Unknown",
+			wantEvidence: "Task stopped by user",
+		},
+		{
+			name: "events about other tasks",
+			task: stopped(ecsTypes.TaskStopCodeUserInitiated, "Task stopped by user"),
+			events: []ecstrace.TimelineEvent{
+				ecstrace.NewEvent(base.Add(-time.Hour), "SERVICE", "(service web) (task bbbb2222) failed container health checks."),
+				ecstrace.NewEvent(base.Add(-time.Hour), "SERVICE", "(service web) has deregistered 1 targets in (target-group arn:aws:elasticloadbalancing:...)"),
+			},
+			wantCategory: "Unknown",
+			wantEvidence: "Task stopped by user",
+		},
+		{
+			name: "earlier health check of this task",
+			task: stopped(ecsTypes.TaskStopCodeServiceSchedulerInitiated, "Scaling activity initiated by (deployment ecs-svc/123)"),
+			events: []ecstrace.TimelineEvent{
+				ecstrace.NewEvent(base.Add(-time.Hour), "SERVICE", "(service web) (task aaaa1111) failed container health checks."),
+			},
+			wantCategory: "Failed health check",
+			wantEvidence: "(task aaaa1111)",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := arnToName(tt.arn)
-			if got != tt.expected {
-				t.Errorf("arnToName(%s) = %s; want %s", tt.arn, got, tt.expected)
+			events := append(tt.events, ecstrace.TaskLifecycleEvents(tt.task)...)
+			diags := diagnoseTask(diagnoseInput{task: tt.task, containers: tt.containers, events: events})
+
+			var found *diagnosis
+			for i := range diags {
+				if diags[i].Category == tt.wantCategory {
+					found = &diags[i]
+				}
+			}
+			if found == nil {
+				t.Fatalf("category %q not found in %+v", tt.wantCategory, diags)
+			}
+			ok := false
+			for _, e := range found.Evidence {
+				if strings.Contains(e.Message, tt.wantEvidence) {
+					ok = true
+				}
+			}
+			if !ok {
+				t.Errorf("evidence does not contain %q: %+v", tt.wantEvidence, found.Evidence)
+			}
+
+			var buf bytes.Buffer
+			printDiagnoses(&buf, diags, true)
+			if !strings.Contains(buf.String(), "["+tt.wantCategory+"]") {
+				t.Errorf("output does not contain category:\n%s", buf.String())
 			}
 		})
 	}
 }
 
 // -----------------------------------------------------------------------------
-// テストケース:
-// 1. "first page": 最初のページを取得する場合
-// 2. "second page": 2番目のページを取得する場合
-// 3. "out of range": 範囲外のページを取得する場合
-// 全イベント数: 3
-// - ページサイズ: 2
-//   - ページ0: [0:2] → event1, event2
-//   - ページ1: [2:3] → event3
-//   - ページ2: [4:4] → 空配列（範囲外）
-//
-// -----------------------------------------------------------------------------
-func TestGetPageEvents(t *testing.T) {
-	tl := &Timeline{
-		pageSize: 2,
-		events: []TimelineEvent{
-			{Message: "event1"},
-			{Message: "event2"},
-			{Message: "event3"},
+// ログの取得に失敗したコンテナの表示をテストします。
+// テスト内容:
+// 1. fetchErrors が失敗したコンテナのみを返すこと
+// 2. renderFetchErrors / printFetchSummary に失敗の理由が表示されること
+// -----------------------------------------------------------------------------
+func TestFetchErrors(t *testing.T) {
+	cursors := []*ecstrace.LogStream{
+		{Source: "app", Stream: "ecs/app/abc", Read: 3},
+		{Source: "sidecar", Err: errors.New("AccessDeniedException")},
+	}
+
+	errs := fetchErrors(cursors)
+	if len(errs) != 1 || errs["sidecar"] != "AccessDeniedException" {
+		t.Errorf("fetchErrors() = %v", errs)
+	}
+	if fetchErrors(cursors[:1]) != nil {
+		t.Error("fetchErrors() should be nil without failures")
+	}
+
+	want := "sidecar: failed to fetch logs: AccessDeniedException"
+	if got := renderFetchErrors(cursors, true); got != want+"\n" {
+		t.Errorf("renderFetchErrors() = %q, want %q", got, want+"\n")
+	}
+
+	var buf bytes.Buffer
+	orig := statusOut
+	statusOut = &buf
+	defer func() { statusOut = orig }()
+	printFetchSummary(cursors, ecstrace.FetchOptions{Limit: 400})
+	if !strings.Contains(buf.String(), want) || !strings.Contains(buf.String(), "app: 3 events") {
+		t.Errorf("summary does not contain both containers: %q", buf.String())
+	}
+}
+
+// -----------------------------------------------------------------------------
+// -service 用の補助関数のテストです。
+// テスト内容:
+// 1. shortTaskID がタスク ID を8文字に短縮すること
+// 2. -source のコンテナ名が "container@shortTaskID" のソースにも一致すること
+// 3. renderServiceHeader にサービス名とタスクごとの行が表示されること
+// -----------------------------------------------------------------------------
+func TestServiceTrace(t *testing.T) {
+	arn := "arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/0123456789abcdef0123456789abcdef"
+	if got := ecstrace.ShortTaskID(arn); got != "01234567" {
+		t.Errorf("ecstrace.ShortTaskID() = %q, want 01234567", got)
+	}
+	if got := ecstrace.ShortTaskID("arn:aws:ecs:ap-northeast-1:123456789012:task/abc"); got != "abc" {
+		t.Errorf("ecstrace.ShortTaskID() = %q, want abc", got)
+	}
+
+	filter, err := ecstrace.NewFilter("", "", "app", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Match(ecstrace.TimelineEvent{Source: "app@01234567"}) {
+		t.Error("-source app should match app@01234567")
+	}
+	if filter.Match(ecstrace.TimelineEvent{Source: "sidecar@01234567"}) {
+		t.Error("-source app should not match sidecar@01234567")
+	}
+
+	tasks := []ecsTypes.Task{
+		{
+			TaskArn:           aws.String(arn),
+			LastStatus:        aws.String("RUNNING"),
+			TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:3"),
+		},
+		{
+			TaskArn:           aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/fedcba9876543210"),
+			LastStatus:        aws.String("STOPPED"),
+			TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:2"),
+			StoppedReason:     aws.String("Scaling activity initiated by (deployment ecs-svc/123)"),
 		},
 	}
+	header := renderServiceHeader("web", tasks, true)
+	for _, want := range []string{"Service: web (2 tasks)", "01234567  RUNNING", "fedcba98  STOPPED", "web:2", "Scaling activity"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header does not contain %q:\n%s", want, header)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// renderDeploymentHeader にロールアウトの状態と失敗したタスク数が表示されることをテストします。
+// -----------------------------------------------------------------------------
+func TestRenderDeploymentHeader(t *testing.T) {
+	summary := ecstrace.DeploymentSummary{
+		ID:                     "ecs-svc/222",
+		Status:                 "PRIMARY",
+		RolloutState:           "FAILED",
+		RolloutStateReason:     "ECS deployment circuit breaker: tasks failed to start.",
+		TaskDefinition:         "web:2",
+		CreatedAt:              time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+		DesiredCount:           2,
+		FailedTasks:            3,
+		CircuitBreaker:         true,
+		CircuitBreakerRollback: true,
+	}
 
+	header := renderDeploymentHeader(summary, true)
+	for _, want := range []string{
+		"Deployment: ecs-svc/222 (PRIMARY, web:2)",
+		"Rollout: FAILED (ECS deployment circuit breaker: tasks failed to start.)",
+		"failed 3",
+		"Circuit breaker: enabled, rollback",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header does not contain %q:\n%s", want, header)
+		}
+	}
+}
+
+// dataOut / statusOut を差し替えて実行し、出力を返す
+func captureOutput(t *testing.T, run func() error) (data, status string) {
+	t.Helper()
+	var dataBuf, statusBuf bytes.Buffer
+	origData, origStatus := dataOut, statusOut
+	dataOut, statusOut = &dataBuf, &statusBuf
+	defer func() { dataOut, statusOut = origData, origStatus }()
+
+	if err := run(); err != nil {
+		t.Fatalf("run failed: %v\nstatus:\n%s", err, statusBuf.String())
+	}
+	return dataBuf.String(), statusBuf.String()
+}
+
+func testTraceOptions(format outputFormat) traceOptions {
+	return traceOptions{
+		format:  format,
+		noPager: true,
+		fetch:   ecstrace.FetchOptions{Limit: ecstrace.DefaultLogLimit, Concurrency: ecstrace.DefaultFetchConcurrency},
+	}
+}
+
+// -----------------------------------------------------------------------------
+// runTrace をフェイクの AWS に対して実行するテストです。
+// テスト内容:
+// 1. ヘッダー・コンテナログ・サービスイベント・TASK イベントがプレーンテキストで出力されること
+// 2. 接頭辞のないストリームが DescribeLogStreams で見つかること
+// 3. ストリームがまだないコンテナはその旨が表示されること
+// 4. -output json でタスク情報とイベントが出力されること
+// 5. 1つのコンテナの取得に失敗しても、他のコンテナのログは表示されること
+// 6. -limit で末尾から指定件数だけ読み、打ち切りが表示されること (残りがちょうど -limit 件なら表示しない)
+// 7. -sources で指定したソースのイベントのみ出力されること
+// 8. 結合した複数行のログがプレーン出力で時刻・ソースと同じ1行になること
+// 9. -follow で最初のイベントの後に新しいイベントを追記し、タスクが STOPPED になったら終了すること
+// -----------------------------------------------------------------------------
+func TestRunTrace(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("plain text", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		data, status := captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, testTraceOptions(formatText))
+		})
+
+		for _, want := range []string{
+			"Task ARN: " + ecstracetest.TaskArn,
+			"app\tserver started",
+			"app\t{\"level\":\"error\",\"msg\":\"db timeout\"}",
+			"sidecar\tproxy ready",
+			"SERVICE\t(service web) has started 1 tasks.",
+			"TASK\tTask started",
+		} {
+			if !strings.Contains(data, want) {
+				t.Errorf("output does not contain %q:\n%s", want, data)
+			}
+		}
+		if strings.Contains(data, "belongs to another task") {
+			t.Errorf("output contains another task's log:\n%s", data)
+		}
+		if !strings.Contains(status, "worker: no log stream yet") {
+			t.Errorf("status does not report the missing stream:\n%s", status)
+		}
+		if f.CallCount("DescribeLogStreams") == 0 {
+			t.Error("DescribeLogStreams was not called for the stream without a prefix")
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		data, _ := captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, testTraceOptions(formatJSON))
+		})
+
+		var doc jsonDocument
+		if err := json.Unmarshal([]byte(data), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, data)
+		}
+		if doc.TaskArn != ecstracetest.TaskArn || len(doc.Containers) != 3 {
+			t.Errorf("metadata = %+v", doc.taskMetadata)
+		}
+		// app 3件 + sidecar 1件 + サービスイベント 1件 + TASK 2件
+		if len(doc.Events) != 7 {
+			t.Errorf("expected 7 events, got %d: %+v", len(doc.Events), doc.Events)
+		}
+		for _, e := range doc.Events {
+			if e.Message == `{"level":"error","msg":"db timeout"}` && e.Level != "ERROR" {
+				t.Errorf("structured log level = %q, want ERROR", e.Level)
+			}
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		f.LogErrors["ecs/app/"+ecstracetest.TaskID] = errors.New("AccessDeniedException: not authorized")
+		data, status := captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, testTraceOptions(formatJSON))
+		})
+
+		var doc jsonDocument
+		if err := json.Unmarshal([]byte(data), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, data)
+		}
+		if !strings.Contains(doc.LogErrors["app"], "AccessDeniedException") {
+			t.Errorf("log_errors = %v", doc.LogErrors)
+		}
+		found := false
+		for _, e := range doc.Events {
+			if e.Source == "sidecar" {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("sidecar logs should still be returned")
+		}
+		if !strings.Contains(status, "app: failed to fetch logs") {
+			t.Errorf("status does not report the failure:\n%s", status)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		opts := testTraceOptions(formatText)
+		opts.fetch.Limit = 2
+		data, status := captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, opts)
+		})
+
+		if strings.Contains(data, "server started") || !strings.Contains(data, "GET /health 200") {
+			t.Errorf("expected only the newest 2 app logs:\n%s", data)
+		}
+		if !strings.Contains(status, "app: 2 events (stopped at -limit 2") {
+			t.Errorf("status does not report truncation:\n%s", status)
+		}
+
+		// 残りがちょうど -limit 件なら打ち切りとして表示しない
+		f = ecstracetest.NewWebFixture()
+		opts.fetch.Limit = 3
+		data, status = captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, opts)
+		})
+		if !strings.Contains(data, "server started") {
+			t.Errorf("expected all 3 app logs:\n%s", data)
+		}
+		if !strings.Contains(status, "app: 3 events") || strings.Contains(status, "stopped at -limit") {
+			t.Errorf("status reports truncation for a stream with exactly -limit events:\n%s", status)
+		}
+
+		// 期間指定でも同じ。打ち切っていなければ Follow 用に末尾を探し直さない
+		f = ecstracetest.NewWebFixture()
+		opts.fetch.Window = ecstrace.TimeWindow{Start: ecstracetest.Base}
+		_, status = captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, opts)
+		})
+		if !strings.Contains(status, "app: 3 events") || strings.Contains(status, "stopped at -limit") {
+			t.Errorf("status reports truncation for a windowed stream with exactly -limit events:\n%s", status)
+		}
+		for _, req := range f.LogEventsRequests {
+			if aws.ToString(req.LogStreamName) == "ecs/app/"+ecstracetest.TaskID && !aws.ToBool(req.StartFromHead) {
+				t.Errorf("unexpected tail read of the app stream: %+v", req)
+			}
+		}
+	})
+
+	t.Run("multiline plain", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		f.AddLogs("/ecs/web", "ecs/app/"+ecstracetest.TaskID,
+			[]int64{ecstracetest.Millis(23 * time.Second), ecstracetest.Millis(23 * time.Second)},
+			[]string{`Exception in thread "main" java.lang.IllegalStateException: boom`, "\tat com.example.App.main(App.java:10)"})
+		opts := testTraceOptions(formatText)
+		opts.fetch.MultilinePattern = `^\S`
+		data, _ := captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, opts)
+		})
+
+		want := "app\tException in thread \"main\" java.lang.IllegalStateException: boom ⏎ \tat com.example.App.main(App.java:10)\n"
+		if !strings.Contains(data, want) {
+			t.Errorf("merged event is not on one line with its source:\n%s", data)
+		}
+
+		// -follow のプレーン出力も同じく1行にする
+		var buf bytes.Buffer
+		enc := newEventEncoder(&buf, formatText, taskMetadata{}, true)
+		merged := ecstrace.NewEvent(ecstracetest.Base, "app", "panic: boom\ngoroutine 1 [running]:")
+		if err := enc.Encode([]ecstrace.TimelineEvent{merged}); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != "2024-01-01 00:00:00\tapp\tpanic: boom ⏎ goroutine 1 [running]:\n" {
+			t.Errorf("follow output = %q", got)
+		}
+	})
+
+	t.Run("sources", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		opts := testTraceOptions(formatText)
+		opts.fetch.Sources = []string{ecstrace.LogsSourceName}
+		data, _ := captureOutput(t, func() error {
+			return runTrace(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, opts)
+		})
+
+		if !strings.Contains(data, "app\tserver started") {
+			t.Errorf("output does not contain container logs:\n%s", data)
+		}
+		if strings.Contains(data, "SERVICE\t") || strings.Contains(data, "TASK\t") {
+			t.Errorf("output contains disabled sources:\n%s", data)
+		}
+		if f.CallCount("DescribeServices") != 0 {
+			t.Error("DescribeServices was called for a disabled source")
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		ecsClient := &stoppingECS{FakeAWS: f, onPoll: func(poll int) {
+			switch poll {
+			case 1:
+				f.AddLogs("/ecs/web", "ecs/app/"+ecstracetest.TaskID,
+					[]int64{ecstracetest.Millis(30 * time.Second), ecstracetest.Millis(31 * time.Second)},
+					[]string{"GET /ready 200", "GET /health 200"})
+			case 2:
+				task := ecstracetest.NewWebTask(ecstracetest.TaskArn)
+				task.LastStatus = aws.String("STOPPED")
+				task.StoppedAt = aws.Time(ecstracetest.Base.Add(40 * time.Second))
+				f.AddTask(task)
+			}
+		}}
+		opts := testTraceOptions(formatNDJSON)
+		opts.follow = true
+		opts.followInterval = time.Millisecond
+		filter, err := ecstrace.NewFilter("", "health", "", "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.filter = filter
+		data, status := captureOutput(t, func() error {
+			return runTrace(ctx, ecsClient, f, ecstracetest.Cluster, ecstracetest.TaskID, opts)
+		})
+
+		var messages []string
+		for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+			var e struct {
+				Source  string `json:"source"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("invalid NDJSON line %q: %v", line, err)
+			}
+			messages = append(messages, e.Source+"\t"+e.Message)
+		}
+		// 最初の取得分・追記分とも時刻順で、-exclude の対象は出力しない
+		want := []string{
+			"TASK\tTask created",
+			"SERVICE\t(service web) has started 1 tasks.",
+			"TASK\tTask started",
+			"sidecar\tproxy ready",
+			"app\tserver started",
+			"app\t{\"level\":\"error\",\"msg\":\"db timeout\"}",
+			"app\tGET /ready 200",
+			"TASK\tTask stopped",
+		}
+		if strings.Join(messages, "|") != strings.Join(want, "|") {
+			t.Errorf("events = %q, want %q", messages, want)
+		}
+		for _, want := range []string{"Following new events", "Task reached STOPPED."} {
+			if !strings.Contains(status, want) {
+				t.Errorf("status does not contain %q:\n%s", want, status)
+			}
+		}
+	})
+}
+
+// Follow のポーリングのたびに onPoll を呼ぶ ECS のフェイク
+// 最初の DescribeTasks は TraceTask なので、2回目以降をポーリングとして数える
+type stoppingECS struct {
+	*ecstracetest.FakeAWS
+	onPoll func(poll int)
+	calls  int
+}
+
+func (s *stoppingECS) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	if s.calls > 0 {
+		s.onPoll(s.calls)
+	}
+	s.calls++
+	return s.FakeAWS.DescribeTasks(ctx, params, optFns...)
+}
+
+// -----------------------------------------------------------------------------
+// runServiceTrace をフェイクの AWS に対して実行するテストです。
+// テスト内容:
+// 1. サービスの全タスクのログが "container@shortTaskID" のソースでまとめて出力されること
+// 2. サービスイベントは1回だけ出力されること
+// 3. -deployment でデプロイの状態がヘッダーに表示されること
+// -----------------------------------------------------------------------------
+func TestRunServiceTrace(t *testing.T) {
+	ctx := context.Background()
+	f := ecstracetest.NewWebFixture()
+	secondID := "fedcba9876543210"
+	f.AddTask(ecstracetest.NewWebTask("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/" + secondID))
+	f.AddLogs("/ecs/web", "ecs/app/"+secondID, []int64{ecstracetest.Millis(30 * time.Second)}, []string{"second replica"})
+
+	data, _ := captureOutput(t, func() error {
+		return runServiceTrace(ctx, f, f, ecstracetest.Cluster, "web", testTraceOptions(formatText))
+	})
+	for _, want := range []string{
+		"Service: web (2 tasks)",
+		"app@01234567\tserver started",
+		"app@fedcba98\tsecond replica",
+		"TASK@fedcba98\tTask started",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("output does not contain %q:\n%s", want, data)
+		}
+	}
+	if n := strings.Count(data, "has started 1 tasks."); n != 1 {
+		t.Errorf("service event printed %d times, want 1", n)
+	}
+
+	opts := testTraceOptions(formatText)
+	opts.deployment = ecstrace.LatestDeployment
+	data, _ = captureOutput(t, func() error {
+		return runServiceTrace(ctx, f, f, ecstracetest.Cluster, "web", opts)
+	})
+	if !strings.Contains(data, "Deployment: ecs-svc/222 (PRIMARY, web:1)") || !strings.Contains(data, "Rollout: IN_PROGRESS") {
+		t.Errorf("output does not contain the deployment header:\n%s", data)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// runQuery をフェイクの AWS に対して実行するテストです。
+// テスト内容:
+// 1. 集計結果は列を揃えた表で出力されること
+// 2. -output csv では列名のヘッダー付きで出力されること
+// 3. @timestamp / @message を含む結果はコンテナ名のソースで Timeline として出力されること
+// 4. ストリーム名が決まらないコンテナはタスク ID で絞り込まれること
+// -----------------------------------------------------------------------------
+func TestRunQuery(t *testing.T) {
+	ctx := context.Background()
+	field := func(name, value string) cwlTypes.ResultField {
+		return cwlTypes.ResultField{Field: aws.String(name), Value: aws.String(value)}
+	}
+	stats := [][]cwlTypes.ResultField{
+		{field("bin(1m)", "2024-01-01 00:00:00.000"), field("count(*)", "12")},
+		{field("bin(1m)", "2024-01-01 00:01:00.000"), field("count(*)", "3")},
+	}
+
+	t.Run("table", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		f.QueryResults = stats
+		data, status := captureOutput(t, func() error {
+			return runQuery(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, "stats count(*) by bin(1m)", testTraceOptions(formatText))
+		})
+
+		for _, want := range []string{
+			"Task ARN: " + ecstracetest.TaskArn,
+			"bin(1m)                  count(*)",
+			"2024-01-01 00:01:00.000  3",
+		} {
+			if !strings.Contains(data, want) {
+				t.Errorf("output does not contain %q:\n%s", want, data)
+			}
+		}
+		if !strings.Contains(status, "Query matched 2 records") {
+			t.Errorf("status does not contain the statistics:\n%s", status)
+		}
+		query := aws.ToString(f.StartedQueries[0].QueryString)
+		if !strings.Contains(query, "@logStream like /"+ecstracetest.TaskID+"|"+ecstracetest.SidecarRuntimeID+"/") {
+			t.Errorf("query does not match the stream without a prefix by task ID and runtime ID: %q", query)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		f.QueryResults = stats
+		data, _ := captureOutput(t, func() error {
+			return runQuery(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, "stats count(*) by bin(1m)", testTraceOptions(formatCSV))
+		})
+		want := "bin(1m),count(*)\n2024-01-01 00:00:00.000,12\n2024-01-01 00:01:00.000,3\n"
+		if data != want {
+			t.Errorf("got:\n%s\nwant:\n%s", data, want)
+		}
+	})
+
+	t.Run("timeline", func(t *testing.T) {
+		f := ecstracetest.NewWebFixture()
+		f.QueryResults = [][]cwlTypes.ResultField{
+			{field("@timestamp", "2024-01-01 00:00:21.000"), field("@logStream", "ecs/app/"+ecstracetest.TaskID), field("@message", "db timeout")},
+		}
+		data, _ := captureOutput(t, func() error {
+			return runQuery(ctx, f, f, ecstracetest.Cluster, ecstracetest.TaskID, "fields @timestamp, @logStream, @message", testTraceOptions(formatText))
+		})
+		if !strings.Contains(data, "app\tdb timeout") {
+			t.Errorf("output does not contain the event:\n%s", data)
+		}
+	})
+}
+
+// -----------------------------------------------------------------------------
+// resolveEndpoint / validateEndpointURL のテストです。
+// テスト内容:
+// 1. -endpoint-url がサービスごとの環境変数より優先されること
+// 2. サービスごとの環境変数が AWS_ENDPOINT_URL より優先されること
+// 3. どれも指定がなければ空 (既定のエンドポイント) になること
+// 4. スキームやホストのない URL はエラーになること
+// -----------------------------------------------------------------------------
+func TestResolveEndpoint(t *testing.T) {
 	tests := []struct {
-		name     string
-		page     int
-		expected int
+		name        string
+		flagValue   string
+		serviceEnv  string
+		genericEnv  string
+		expectedURL string
 	}{
-		{"first page", 0, 2},
-		{"second page", 1, 1},
-		{"out of range", 2, 0},
+		{"flag wins", "http://flag:4566", "http://ecs:4566", "http://all:4566", "http://flag:4566"},
+		{"service env", "", "http://ecs:4566", "http://all:4566", "http://ecs:4566"},
+		{"generic env", "", "", "http://all:4566", "http://all:4566"},
+		{"default", "", "", "", ""},
 	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tl.getPageEvents(tt.page)
-			if len(got) != tt.expected {
-				t.Errorf("got %d events, want %d", len(got), tt.expected)
+			t.Setenv(ecsEndpointEnv, tt.serviceEnv)
+			t.Setenv(genericEndpointEnv, tt.genericEnv)
+			if got := resolveEndpoint(tt.flagValue, ecsEndpointEnv); got != tt.expectedURL {
+				t.Errorf("resolveEndpoint() = %q, want %q", got, tt.expectedURL)
 			}
 		})
 	}
+
+	for _, s := range []string{"localhost:4566", "http://", "://x"} {
+		if err := validateEndpointURL(s); err == nil {
+			t.Errorf("validateEndpointURL(%q) expected error", s)
+		}
+	}
+	if err := validateEndpointURL("http://localhost:4566"); err != nil {
+		t.Errorf("validateEndpointURL() error = %v", err)
+	}
 }
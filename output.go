@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"logs-ecstask/ecstrace"
+)
+
+// 出力形式
+type outputFormat string
+
+const (
+	formatText   outputFormat = "text"
+	formatJSON   outputFormat = "json"
+	formatNDJSON outputFormat = "ndjson"
+	formatCSV    outputFormat = "csv"
+)
+
+// 進捗や対話プロンプトの出力先
+// 機械可読形式のときは stdout を汚さないよう stderr に切り替える
+var statusOut io.Writer = os.Stdout
+
+// イベントや診断結果などデータの出力先 (テストでは差し替える)
+var dataOut io.Writer = os.Stdout
+
+// ecstrace の進捗を statusOut に、警告をログに出力する
+type statusLogger struct{}
+
+func (statusLogger) Progressf(format string, args ...any) {
+	fmt.Fprintln(statusOut, waitStyle.Render(fmt.Sprintf(format, args...)))
+}
+
+func (statusLogger) Warnf(format string, args ...any) {
+	log.Printf(format, args...)
+}
+
+// -output の値を検証
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch f := outputFormat(s); f {
+	case formatText, formatJSON, formatNDJSON, formatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format: %s (text, json, ndjson, csv)", s)
+}
+
+// タスクのメタデータ
+type taskMetadata struct {
+	Cluster    string                      `json:"cluster"`
+	TaskArn    string                      `json:"task_arn"`
+	LastStatus string                      `json:"last_status"`
+	Containers []ecstrace.ContainerSummary `json:"containers,omitempty"`
+	// -service ではサービス名と対象のタスクの一覧
+	Service    string                      `json:"service,omitempty"`
+	Deployment *ecstrace.DeploymentSummary `json:"deployment,omitempty"`
+	Tasks      []ecstrace.TaskSummary      `json:"tasks,omitempty"`
+	// ログの取得に失敗したコンテナとその理由
+	LogErrors map[string]string `json:"log_errors,omitempty"`
+}
+
+// JSON / NDJSON 出力用のイベント
+type jsonEvent struct {
+	Timestamp string            `json:"timestamp"`
+	Source    string            `json:"source"`
+	Message   string            `json:"message"`
+	Level     string            `json:"level,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Logger    string            `json:"logger,omitempty"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// NDJSON は1行で完結するようにタスク情報も各行に含める
+type ndjsonEvent struct {
+	jsonEvent
+	TaskArn    string `json:"task_arn"`
+	LastStatus string `json:"last_status"`
+}
+
+// JSON 出力全体
+type jsonDocument struct {
+	taskMetadata
+	Events []jsonEvent `json:"events"`
+}
+
+func toJSONEvent(e ecstrace.TimelineEvent) jsonEvent {
+	return jsonEvent{
+		Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+		Source:    e.Source,
+		Message:   e.Message,
+		Level:     e.Level,
+		Body:      e.Body,
+		Logger:    e.Logger,
+		TraceID:   e.TraceID,
+		Fields:    e.Fields,
+	}
+}
+
+// イベントを逐次書き出すエンコーダ
+// -follow ではポーリングのたびに Encode が呼ばれ、終了時に Close される
+type eventEncoder interface {
+	Encode(events []ecstrace.TimelineEvent) error
+	Close() error
+}
+
+// 形式に対応するエンコーダを作成
+// plain はテキスト形式のときのみ使う
+func newEventEncoder(w io.Writer, format outputFormat, meta taskMetadata, plain bool) eventEncoder {
+	switch format {
+	case formatJSON:
+		return &jsonEncoder{w: w, doc: jsonDocument{taskMetadata: meta, Events: []jsonEvent{}}}
+	case formatNDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w), meta: meta}
+	case formatCSV:
+		return &csvEncoder{w: csv.NewWriter(w), meta: meta}
+	default:
+		return &textEncoder{w: w, plain: plain}
+	}
+}
+
+// ページングなしのテキスト出力
+// plain の場合は色・ヘッダーなしで1イベント1行にする
+type textEncoder struct {
+	w             io.Writer
+	plain         bool
+	headerWritten bool
+}
+
+func (t *textEncoder) Encode(events []ecstrace.TimelineEvent) error {
+	if !t.headerWritten && !t.plain {
+		fmt.Fprintln(t.w, renderHeader())
+		t.headerWritten = true
+	}
+	for _, e := range ecstrace.SortedAscending(events) {
+		line := renderEvent(e)
+		if t.plain {
+			line = renderPlainEvent(e)
+		}
+		if _, err := fmt.Fprintln(t.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *textEncoder) Close() error { return nil }
+
+// JSON は1つのドキュメントにまとめるため Close 時に書き出す
+type jsonEncoder struct {
+	w   io.Writer
+	doc jsonDocument
+}
+
+func (j *jsonEncoder) Encode(events []ecstrace.TimelineEvent) error {
+	for _, e := range ecstrace.SortedAscending(events) {
+		j.doc.Events = append(j.doc.Events, toJSONEvent(e))
+	}
+	return nil
+}
+
+func (j *jsonEncoder) Close() error {
+	enc := json.NewEncoder(j.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(j.doc)
+}
+
+// 1イベント1行の JSON
+type ndjsonEncoder struct {
+	enc  *json.Encoder
+	meta taskMetadata
+}
+
+func (n *ndjsonEncoder) Encode(events []ecstrace.TimelineEvent) error {
+	for _, e := range ecstrace.SortedAscending(events) {
+		line := ndjsonEvent{
+			jsonEvent:  toJSONEvent(e),
+			TaskArn:    n.meta.TaskArn,
+			LastStatus: n.meta.LastStatus,
+		}
+		if err := n.enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ndjsonEncoder) Close() error { return nil }
+
+// ヘッダー付き CSV
+type csvEncoder struct {
+	w             *csv.Writer
+	meta          taskMetadata
+	headerWritten bool
+}
+
+func (c *csvEncoder) Encode(events []ecstrace.TimelineEvent) error {
+	if !c.headerWritten {
+		if err := c.w.Write([]string{"timestamp", "source", "message", "task_arn", "last_status"}); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	for _, e := range ecstrace.SortedAscending(events) {
+		je := toJSONEvent(e)
+		if err := c.w.Write([]string{je.Timestamp, je.Source, je.Message, c.meta.TaskArn, c.meta.LastStatus}); err != nil {
+			return err
+		}
+	}
+	// -follow で逐次出力されるよう都度 Flush する
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvEncoder) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"logs-ecstask/ecstrace"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// 全画面ページャーの列幅
+const (
+	pagerTimeWidth   = 21
+	pagerSourceWidth = 25
+	pagerFieldWidth  = 20
+)
+
+var (
+	pagerHeaderStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#00bfff")).
+				Bold(true)
+
+	pagerSelectedStyle = lipgloss.NewStyle().
+				Reverse(true)
+
+	pagerStatusStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#808080"))
+
+	pagerSearchStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#ffff00"))
+
+	pagerDetailStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#f5f5f5")).
+				BorderStyle(lipgloss.NormalBorder()).
+				BorderTop(true).
+				BorderForeground(lipgloss.Color("#808080"))
+)
+
+// Timeline を全画面で閲覧するための bubbletea モデル
+type pagerModel struct {
+	// 表示順に並んだイベント
+	events      []ecstrace.TimelineEvent
+	newestFirst bool
+	filtered    int
+	// 画面上部に固定表示するタスク情報
+	header []string
+
+	// 選択中のイベントと、画面先頭のイベント
+	cursor int
+	top    int
+
+	width  int
+	height int
+
+	// "/" 検索
+	searching    bool
+	query        string
+	searchOrigin int
+
+	// 選択中のイベントの詳細表示
+	detail bool
+}
+
+// events は新しい順にソート済みであること
+func newPagerModel(events []ecstrace.TimelineEvent, filtered int, header string) pagerModel {
+	m := pagerModel{
+		events:      events,
+		newestFirst: true,
+		filtered:    filtered,
+		width:       80,
+		height:      24,
+	}
+	if header = strings.TrimRight(header, "\n"); header != "" {
+		m.header = strings.Split(header, "\n")
+	}
+	return m
+}
+
+func (m pagerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m pagerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.ensureVisible()
+		return m, nil
+
+	case tea.KeyMsg:
+		if m.searching {
+			return m.updateSearch(msg)
+		}
+		return m.updateNormal(msg)
+	}
+	return m, nil
+}
+
+// 通常時のキー操作
+func (m pagerModel) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.detail = false
+		m.query = ""
+	case "j", "down":
+		m.moveCursor(1)
+	case "k", "up":
+		m.moveCursor(-1)
+	case "f", " ", "pgdown", "ctrl+f":
+		m.moveCursor(m.bodyHeight())
+	case "b", "pgup", "ctrl+b":
+		m.moveCursor(-m.bodyHeight())
+	case "ctrl+d":
+		m.moveCursor(m.bodyHeight() / 2)
+	case "ctrl+u":
+		m.moveCursor(-m.bodyHeight() / 2)
+	case "g", "home":
+		m.cursor = 0
+	case "G", "end":
+		m.cursor = len(m.events) - 1
+	case "/":
+		m.searching = true
+		m.query = ""
+		m.searchOrigin = m.cursor
+	case "n":
+		m.jumpToMatch(m.cursor+1, 1)
+	case "N":
+		m.jumpToMatch(m.cursor-1, -1)
+	case "s":
+		m.toggleOrder()
+	case "enter":
+		m.detail = !m.detail
+	}
+	m.ensureVisible()
+	return m, nil
+}
+
+// 検索入力中のキー操作 (入力のたびに一致するイベントへ移動する)
+func (m pagerModel) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.searching = false
+		m.query = ""
+		m.cursor = m.searchOrigin
+	case tea.KeyEnter:
+		m.searching = false
+	case tea.KeyBackspace:
+		if r := []rune(m.query); len(r) > 0 {
+			m.query = string(r[:len(r)-1])
+		}
+		m.cursor = m.searchOrigin
+		m.jumpToMatch(m.searchOrigin, 1)
+	case tea.KeySpace:
+		m.query += " "
+		m.jumpToMatch(m.searchOrigin, 1)
+	case tea.KeyRunes:
+		m.query += string(msg.Runes)
+		m.jumpToMatch(m.searchOrigin, 1)
+	}
+	m.ensureVisible()
+	return m, nil
+}
+
+func (m *pagerModel) moveCursor(delta int) {
+	m.cursor = max(0, min(m.cursor+delta, len(m.events)-1))
+}
+
+// イベントが検索語を含むか (大文字小文字を区別しない)
+func (m pagerModel) matches(e ecstrace.TimelineEvent) bool {
+	if m.query == "" {
+		return false
+	}
+	q := strings.ToLower(m.query)
+	return strings.Contains(strings.ToLower(e.Message), q) ||
+		strings.Contains(strings.ToLower(e.Source), q)
+}
+
+// from から dir 方向に一致するイベントを探し、見つかれば移動する (端で折り返す)
+func (m *pagerModel) jumpToMatch(from, dir int) bool {
+	n := len(m.events)
+	if n == 0 || m.query == "" {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		idx := ((from+dir*i)%n + n) % n
+		if m.matches(m.events[idx]) {
+			m.cursor = idx
+			return true
+		}
+	}
+	return false
+}
+
+// 新しい順 / 古い順を切り替える (選択中のイベントは維持する)
+func (m *pagerModel) toggleOrder() {
+	for i, j := 0, len(m.events)-1; i < j; i, j = i+1, j-1 {
+		m.events[i], m.events[j] = m.events[j], m.events[i]
+	}
+	if len(m.events) > 0 {
+		m.cursor = len(m.events) - 1 - m.cursor
+	}
+	m.newestFirst = !m.newestFirst
+}
+
+// 詳細表示の高さ
+func (m pagerModel) detailHeight() int {
+	if !m.detail || len(m.events) == 0 {
+		return 0
+	}
+	return max(m.height/2, 3)
+}
+
+// イベント一覧の表示行数 (タスク情報・列見出し・ステータス行を除く)
+func (m pagerModel) bodyHeight() int {
+	return max(m.height-2-len(m.header)-m.detailHeight(), 1)
+}
+
+// 選択中のイベントが画面内に収まるよう先頭位置を調整
+func (m *pagerModel) ensureVisible() {
+	if len(m.events) == 0 {
+		m.cursor, m.top = 0, 0
+		return
+	}
+	m.cursor = max(0, min(m.cursor, len(m.events)-1))
+	h := m.bodyHeight()
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+h {
+		m.top = m.cursor - h + 1
+	}
+	m.top = max(0, min(m.top, len(m.events)-1))
+}
+
+func (m pagerModel) View() string {
+	var b strings.Builder
+
+	// タスク情報
+	for _, l := range m.header {
+		b.WriteString(ansi.Truncate(l, m.width, ""))
+		b.WriteString("\n")
+	}
+
+	// ヘッダー
+	header := fitCell("TIME", pagerTimeWidth) + fitCell("Log Source", pagerSourceWidth)
+	for _, f := range displayFields {
+		header += fitCell(f, pagerFieldWidth)
+	}
+	header += "Message"
+	b.WriteString(pagerHeaderStyle.Render(ansi.Truncate(header, m.width, "")))
+	b.WriteString("\n")
+
+	// イベント一覧
+	h := m.bodyHeight()
+	for i := 0; i < h; i++ {
+		idx := m.top + i
+		if idx < len(m.events) {
+			b.WriteString(m.renderRow(idx))
+		}
+		b.WriteString("\n")
+	}
+
+	// 詳細表示
+	if dh := m.detailHeight(); dh > 0 {
+		b.WriteString(m.renderDetail(dh))
+		b.WriteString("\n")
+	}
+
+	b.WriteString(m.renderStatus())
+	return b.String()
+}
+
+// 1イベントを1行に収めて描画
+func (m pagerModel) renderRow(idx int) string {
+	e := m.events[idx]
+
+	msg := e.Message
+	if e.Structured && e.Body != "" {
+		msg = e.Body
+	}
+	if e.Structured && e.Level != "" {
+		msg = "[" + e.Level + "] " + msg
+	}
+	msg = singleLine(msg)
+
+	row := fitCell(e.Timestamp.Format("2006-01-02 15:04:05"), pagerTimeWidth) +
+		fitCell(e.Source, pagerSourceWidth)
+	for _, f := range displayFields {
+		row += fitCell(e.Field(f), pagerFieldWidth)
+	}
+	row = ansi.Truncate(row+msg, m.width, "…")
+
+	if idx == m.cursor {
+		return pagerSelectedStyle.Render(row)
+	}
+	if m.matches(e) {
+		return pagerSearchStyle.Render(row)
+	}
+	return styleForLevel(lipgloss.NewStyle(), e.Level).Render(row)
+}
+
+// 選択中のイベントの全文を折り返して描画
+func (m pagerModel) renderDetail(height int) string {
+	e := m.events[m.cursor]
+
+	lines := []string{
+		fmt.Sprintf("%s  %s", e.Timestamp.Format("2006-01-02 15:04:05.000 MST"), e.Source),
+	}
+	if e.Level != "" {
+		lines = append(lines, "level: "+e.Level)
+	}
+	if e.Logger != "" {
+		lines = append(lines, "logger: "+e.Logger)
+	}
+	if e.TraceID != "" {
+		lines = append(lines, "trace_id: "+e.TraceID)
+	}
+	lines = append(lines, "", e.Message)
+
+	body := lipgloss.NewStyle().Width(m.width).Render(strings.Join(lines, "\n"))
+	wrapped := strings.Split(body, "\n")
+	// 上端の罫線の分を引く
+	if len(wrapped) > height-1 {
+		wrapped = wrapped[:height-1]
+	}
+	return pagerDetailStyle.Width(m.width).Render(strings.Join(wrapped, "\n"))
+}
+
+// 最下行のステータス表示
+func (m pagerModel) renderStatus() string {
+	if m.searching {
+		return pagerSearchStyle.Render("/" + m.query)
+	}
+
+	order := "newest first"
+	if !m.newestFirst {
+		order = "oldest first"
+	}
+	status := fmt.Sprintf("%d/%d  %s", min(m.cursor+1, len(m.events)), len(m.events), order)
+	if m.filtered > 0 {
+		status += fmt.Sprintf("  %d events filtered out", m.filtered)
+	}
+	if m.query != "" {
+		status += fmt.Sprintf("  search: %s", m.query)
+	}
+	status += "  (j/k: line, f/b: page, g/G: top/bottom, /: search, n/N: next/prev, s: sort, Enter: detail, q: quit)"
+	return pagerStatusStyle.Render(ansi.Truncate(status, m.width, "…"))
+}
+
+// 文字列を表示幅 w に切り詰め、足りなければ空白で埋める (列の間に1文字空ける)
+func fitCell(s string, w int) string {
+	s = ansi.Truncate(s, w-1, "…")
+	return s + strings.Repeat(" ", w-ansi.StringWidth(s))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ピッカーに一度に表示する最大行数
+const pickerMaxRows = 15
+
+var errPickerCancelled = errors.New("selection cancelled")
+
+var (
+	pickerCursorStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#00ff00")).
+				Bold(true)
+
+	pickerMatchStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#ffff00"))
+)
+
+// ピッカーの選択肢
+type pickerItem struct {
+	// 絞り込みの対象になる文字列
+	label string
+	// 補足情報 (絞り込みの対象にも含める)
+	detail string
+}
+
+// 入力した文字で絞り込みながら1件選ぶ bubbletea モデル
+type pickerModel struct {
+	title string
+	items []pickerItem
+
+	query   string
+	matches []int
+	cursor  int
+	width   int
+
+	chosen    int
+	cancelled bool
+}
+
+func newPickerModel(title string, items []pickerItem) pickerModel {
+	m := pickerModel{title: title, items: items, chosen: -1, width: 80}
+	m.refilter()
+	return m
+}
+
+func (m pickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m pickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			m.cancelled = true
+			return m, tea.Quit
+		case tea.KeyEnter:
+			// 一致するものがなければ入力を続けてもらう
+			if len(m.matches) > 0 {
+				m.chosen = m.matches[m.cursor]
+				return m, tea.Quit
+			}
+		case tea.KeyUp, tea.KeyCtrlP:
+			m.cursor = max(m.cursor-1, 0)
+		case tea.KeyDown, tea.KeyCtrlN:
+			m.cursor = max(min(m.cursor+1, len(m.matches)-1), 0)
+		case tea.KeyBackspace:
+			if r := []rune(m.query); len(r) > 0 {
+				m.query = string(r[:len(r)-1])
+				m.refilter()
+			}
+		case tea.KeySpace:
+			m.query += " "
+			m.refilter()
+		case tea.KeyRunes:
+			m.query += string(msg.Runes)
+			m.refilter()
+		}
+	}
+	return m, nil
+}
+
+// 入力に一致する選択肢をスコア順に並べ直す
+func (m *pickerModel) refilter() {
+	type scored struct {
+		idx   int
+		score int
+	}
+	var results []scored
+	for i, it := range m.items {
+		if score, ok := fuzzyScore(m.query, it.label+" "+it.detail); ok {
+			results = append(results, scored{i, score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+
+	m.matches = m.matches[:0]
+	for _, r := range results {
+		m.matches = append(m.matches, r.idx)
+	}
+	m.cursor = 0
+}
+
+func (m pickerModel) View() string {
+	if m.chosen >= 0 || m.cancelled {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(choiceStyle.Render(m.title))
+	b.WriteString("\n")
+	b.WriteString(choiceStyle.Render("➡ ") + m.query + "\n")
+
+	// 選択中の行が見えるように表示範囲をずらす
+	start := 0
+	if m.cursor >= pickerMaxRows {
+		start = m.cursor - pickerMaxRows + 1
+	}
+	end := min(start+pickerMaxRows, len(m.matches))
+	for i := start; i < end; i++ {
+		it := m.items[m.matches[i]]
+		line := ansi.Truncate(it.label+"  "+it.detail, m.width-2, "…")
+		if i == m.cursor {
+			b.WriteString(pickerCursorStyle.Render("> " + line))
+		} else {
+			b.WriteString("  " + idStyle.Render(line))
+		}
+		b.WriteString("\n")
+	}
+
+	status := fmt.Sprintf("%d/%d (↑/↓: move, Enter: select, Esc: cancel)", len(m.matches), len(m.items))
+	b.WriteString(pickerMatchStyle.Render(status))
+	return b.String()
+}
+
+// query の文字が target に順番どおり含まれていれば一致とする (大文字小文字は区別しない)
+// 連続した一致や単語の先頭での一致ほどスコアを高くする
+func fuzzyScore(query, target string) (int, bool) {
+	q := []rune(strings.ToLower(strings.TrimSpace(query)))
+	if len(q) == 0 {
+		return 0, true
+	}
+	t := []rune(strings.ToLower(target))
+
+	score, qi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 5
+		}
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1]) {
+			score += 3
+		}
+		prev = ti
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score, true
+}
+
+// 選択肢から1件選び、そのインデックスを返す
+// 端末であれば絞り込み付きのピッカー、そうでなければ番号入力で選ぶ
+func pickOne(title string, items []pickerItem, display func()) (int, error) {
+	if !isTerminal(os.Stdin) {
+		return promptIndex(os.Stdin, len(items), display)
+	}
+
+	res, err := tea.NewProgram(newPickerModel(title, items), tea.WithOutput(statusOut)).Run()
+	if err != nil {
+		return 0, err
+	}
+	m := res.(pickerModel)
+	if m.cancelled {
+		return 0, errPickerCancelled
+	}
+	return m.chosen, nil
+}
+
+// 番号で選ぶ。不正な入力の場合は再入力を求める
+func promptIndex(r io.Reader, n int, display func()) (int, error) {
+	display()
+	reader := bufio.NewReader(r)
+	for {
+		fmt.Fprint(statusOut, choiceStyle.Render("Enter a number ➡ "))
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return 0, err
+		}
+		idx, convErr := strconv.Atoi(strings.TrimSpace(line))
+		if convErr == nil && idx >= 0 && idx < n {
+			return idx, nil
+		}
+		fmt.Fprintln(statusOut, errorStyle.Render(fmt.Sprintf("invalid index, enter a number between 0 and %d", n-1)))
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"logs-ecstask/ecstrace"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// -query の結果を表として出力する場合の JSON
+type queryDocument struct {
+	taskMetadata
+	LogGroups []string            `json:"log_groups"`
+	Queries   []string            `json:"queries"`
+	Fields    []string            `json:"fields"`
+	Rows      []map[string]string `json:"rows"`
+}
+
+// タスクのログストリームに CloudWatch Logs Insights のクエリを実行して出力
+// 結果に @timestamp と @message があれば Timeline として、なければ表として出力する
+func runQuery(ctx context.Context, ecsClient ecstrace.ECSAPI, logsClient ecstrace.LogsAPI, cluster, taskID, query string, opts traceOptions) error {
+	processor := newProcessor(ecsClient, logsClient, cluster)
+	result, err := processor.QueryTask(ctx, taskID, query, opts.fetch.Window)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(statusOut, waitStyle.Render(fmt.Sprintf("Query matched %.0f records (%.0f scanned), %d rows",
+		result.RecordsMatched, result.RecordsScanned, len(result.Rows))))
+
+	task := result.Task
+	taskArn := aws.ToString(task.TaskArn)
+	plain := opts.noPager || !isTerminal(os.Stdout)
+	meta := taskMetadata{
+		Cluster:    cluster,
+		TaskArn:    taskArn,
+		LastStatus: aws.ToString(task.LastStatus),
+		Containers: result.Containers,
+	}
+	header := renderTaskHeader(taskArn, aws.ToString(task.LastStatus), result.Containers, plain)
+
+	if result.HasEvents() {
+		timeline := &ecstrace.Timeline{}
+		for _, e := range result.Events() {
+			timeline.Add(e)
+		}
+		timeline.ApplyFilter(opts.filter)
+		return printTimeline(timeline, header, meta, opts, plain)
+	}
+	return printQueryResult(dataOut, result, header, meta, opts.format, plain)
+}
+
+// クエリ結果を表として出力形式に合わせて出力
+func printQueryResult(w io.Writer, result *ecstrace.QueryResult, header string, meta taskMetadata, format outputFormat, plain bool) error {
+	switch format {
+	case formatJSON:
+		doc := queryDocument{
+			taskMetadata: meta,
+			LogGroups:    result.LogGroups,
+			Queries:      result.Queries,
+			Fields:       result.Fields,
+			Rows:         result.Rows,
+		}
+		if doc.Rows == nil {
+			doc.Rows = []map[string]string{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case formatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, row := range result.Rows {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(result.Fields); err != nil {
+			return err
+		}
+		for _, row := range result.Rows {
+			record := make([]string, len(result.Fields))
+			for i, f := range result.Fields {
+				record[i] = row[f]
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		fmt.Fprintln(w, header)
+		fmt.Fprintln(w, renderQueryTable(result, plain))
+		return nil
+	}
+}
+
+// クエリ結果を列を揃えた表で描画
+// 改行・タブを含む値は1行に収める
+func renderQueryTable(result *ecstrace.QueryResult, plain bool) string {
+	if len(result.Rows) == 0 {
+		return "No results."
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(result.Fields, "\t"))
+	cell := strings.NewReplacer("\n", " ", "\t", " ")
+	for _, row := range result.Rows {
+		values := make([]string, len(result.Fields))
+		for i, f := range result.Fields {
+			values[i] = dashIfEmpty(cell.Replace(row[f]))
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if !plain {
+		lines[0] = taskHeaderStyle.Render(lines[0])
+	}
+	return strings.Join(lines, "\n")
+}
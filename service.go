@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"logs-ecstask/ecstrace"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// サービスの全タスクのログとサービスイベントを1つの Timeline にまとめて出力
+// -deployment が指定されていれば、そのデプロイが起動したタスクに絞る
+func runServiceTrace(ctx context.Context, ecsClient ecstrace.ECSAPI, logsClient ecstrace.LogsAPI, cluster, service string, opts traceOptions) error {
+	processor := newProcessor(ecsClient, logsClient, cluster)
+	trace, err := processor.TraceService(ctx, service, ecstrace.ServiceOptions{
+		Fetch:        opts.fetch,
+		StoppedTasks: opts.stoppedTasks,
+		Deployment:   opts.deployment,
+	})
+	if err != nil {
+		return err
+	}
+	printFetchSummary(trace.LogStreams, opts.fetch)
+
+	trace.Timeline.ApplyFilter(opts.filter)
+
+	plain := opts.noPager || !isTerminal(os.Stdout)
+	header := renderServiceHeader(service, trace.Tasks, plain) + renderFetchErrors(trace.LogStreams, plain)
+	if trace.Deployment != nil {
+		header = renderDeploymentHeader(*trace.Deployment, plain) + header
+	}
+	meta := taskMetadata{
+		Cluster:    cluster,
+		Service:    service,
+		Deployment: trace.Deployment,
+		Tasks:      trace.Summaries,
+		LogErrors:  fetchErrors(trace.LogStreams),
+	}
+	return printTimeline(trace.Timeline, header, meta, opts, plain)
+}
+
+// サービス名と対象のタスクの一覧
+func renderServiceHeader(service string, tasks []ecsTypes.Task, plain bool) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tSTATUS\tDEFINITION\tSTARTED\tSTOPPED REASON")
+	for _, t := range tasks {
+		started := "-"
+		if t.StartedAt != nil {
+			started = aws.ToTime(t.StartedAt).Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			ecstrace.ShortTaskID(aws.ToString(t.TaskArn)), dashIfEmpty(aws.ToString(t.LastStatus)),
+			ecstrace.ArnToName(aws.ToString(t.TaskDefinitionArn)), started, dashIfEmpty(aws.ToString(t.StoppedReason)))
+	}
+	w.Flush()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	title := fmt.Sprintf("Service: %s (%d tasks)", service, len(tasks))
+	if !plain {
+		title = taskHeaderStyle.Render("Service:") + " " + taskMessageStyle.Render(fmt.Sprintf("%s (%d tasks)", service, len(tasks)))
+		lines[0] = taskHeaderStyle.Render(lines[0])
+	}
+	return title + "\n\n" + strings.Join(lines, "\n") + "\n"
+}
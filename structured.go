@@ -0,0 +1,52 @@
+package main
+
+import (
+	"logs-ecstask/ecstrace"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// -fields で列として表示する追加項目のキー
+var displayFields []string
+
+var (
+	fieldStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#c0c0c0")).Width(20).MarginRight(1)
+
+	fieldHeaderStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#00bfff")).
+				Bold(true).Width(20).MarginRight(1)
+
+	levelStyles = map[string]lipgloss.Style{
+		"FATAL": lipgloss.NewStyle().Foreground(lipgloss.Color("#ffffff")).Background(lipgloss.Color("#ff0000")).Bold(true),
+		"ERROR": lipgloss.NewStyle().Foreground(lipgloss.Color("#ff0000")).Bold(true),
+		"WARN":  lipgloss.NewStyle().Foreground(lipgloss.Color("#ffff00")).Bold(true),
+		"INFO":  lipgloss.NewStyle().Foreground(lipgloss.Color("#00ff00")),
+		"DEBUG": lipgloss.NewStyle().Foreground(lipgloss.Color("#808080")),
+		"TRACE": lipgloss.NewStyle().Foreground(lipgloss.Color("#808080")),
+	}
+)
+
+// レベルのバッジを描画
+func renderLevelBadge(level string) string {
+	style, ok := levelStyles[level]
+	if !ok {
+		style = lipgloss.NewStyle()
+	}
+	return style.Render("[" + level + "]")
+}
+
+// Message 列に表示する本文 (JSON ログならレベルと本文のみ)
+func displayMessage(e ecstrace.TimelineEvent) string {
+	if !e.Structured {
+		return e.Message
+	}
+	body := e.Body
+	if body == "" {
+		body = e.Message
+	}
+	if e.Level == "" {
+		return body
+	}
+	return renderLevelBadge(e.Level) + " " + body
+}
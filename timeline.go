@@ -1,13 +1,16 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"sort"
-	"time"
+	"strings"
 
+	"logs-ecstask/ecstrace"
+
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"golang.org/x/term"
 )
@@ -28,111 +31,83 @@ var (
 	headerStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#00bfff")).
 			Bold(true).Width(25).MarginRight(3)
-
-	// ページング用のスタイル
-	pagingStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#808080"))
 )
 
-type Timeline struct {
-	events   []TimelineEvent
-	pageSize int
+// イベントを新しい順に並べ替えたコピーを返す
+func sortedDescending(events []ecstrace.TimelineEvent) []ecstrace.TimelineEvent {
+	sorted := make([]ecstrace.TimelineEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.After(sorted[j].Timestamp)
+	})
+	return sorted
 }
 
-type TimelineEvent struct {
-	Timestamp time.Time
-	Source    string
-	Message   string
+// ヘッダー行を描画
+func renderHeader() string {
+	cols := []string{
+		headerStyle.Render("TIME"),
+		headerStyle.Render("Log Source"),
+	}
+	for _, f := range displayFields {
+		cols = append(cols, fieldHeaderStyle.Render(f))
+	}
+	cols = append(cols, headerStyle.Render("Message"))
+	return lipgloss.JoinHorizontal(lipgloss.Top, cols...)
 }
 
-// Timeline にイベントを追加
-func (tl *Timeline) Add(evt TimelineEvent) {
-	tl.events = append(tl.events, evt)
+// イベント1件分の行を描画
+func renderEvent(e ecstrace.TimelineEvent) string {
+	cols := []string{
+		styleForLevel(timestampStyle, e.Level).Render(e.Timestamp.Format("2006-01-02 15:04:05")),
+		sourceStyle.Render(e.Source),
+	}
+	for _, f := range displayFields {
+		cols = append(cols, fieldStyle.Render(e.Field(f)))
+	}
+	cols = append(cols, styleForLevel(messageStyle, e.Level).Render(displayMessage(e)))
+	return lipgloss.JoinHorizontal(lipgloss.Top, cols...)
 }
 
-// イベントをソート
-func (tl *Timeline) sortEvents() {
-	sort.SliceStable(tl.events, func(i, j int) bool {
-		return tl.events[i].Timestamp.After(tl.events[j].Timestamp)
-	})
+// 装飾なしのイベント1行 (パイプや grep 向け)
+// 結合した複数行のログも時刻・ソースと同じ行に収める
+func renderPlainEvent(e ecstrace.TimelineEvent) string {
+	return fmt.Sprintf("%s\t%s\t%s",
+		e.Timestamp.Format("2006-01-02 15:04:05"), e.Source, singleLine(e.Message))
 }
 
-// ページのイベントを取得
-func (tl *Timeline) getPageEvents(page int) []TimelineEvent {
-	start := page * tl.pageSize
-	if start >= len(tl.events) {
-		return []TimelineEvent{}
-	}
-	end := min(start+tl.pageSize, len(tl.events))
-	return tl.events[start:end]
+// 改行を " ⏎ " に置き換えて1行にする
+func singleLine(msg string) string {
+	return strings.ReplaceAll(msg, "\n", " ⏎ ")
 }
 
-// 1ページ分のイベントを描画
-func (tl *Timeline) renderPage(events []TimelineEvent, currentPage, totalPages int) {
+// stdout がターミナルかどうか
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
 
-	// ヘッダー表示
-	fmt.Println(lipgloss.JoinHorizontal(
-		lipgloss.Top,
-		headerStyle.Render("TIME"),
-		headerStyle.Render("Log Source"),
-		headerStyle.Render("Message"),
-	))
-
-	// イベント表示
-	for _, e := range events {
-		fmt.Println(
-			lipgloss.JoinHorizontal(
-				lipgloss.Top,
-				timestampStyle.Render(e.Timestamp.Format("2006-01-02 15:04:05")),
-				sourceStyle.Render(e.Source),
-				messageStyle.Render(e.Message),
-			))
+// ページング・色なしでヘッダーと全イベントを新しい順に出力
+func printPlain(w io.Writer, tl *ecstrace.Timeline, header string) {
+	if header != "" {
+		fmt.Fprintln(w, header)
+	}
+	for _, e := range sortedDescending(tl.Events()) {
+		fmt.Fprintln(w, renderPlainEvent(e))
 	}
-
-	// ページ情報表示
-	pageText := fmt.Sprintf("Page %d/%d (Next ➡ Enter, Quit: q)",
-		currentPage+1, totalPages)
-	styledText := pagingStyle.Render(pageText)
-	fmt.Println(styledText)
 }
 
 // メイン表示処理
-func (tl *Timeline) Print() {
-	tl.sortEvents()
-
-	// ターミナルの行数を取得してページサイズを設定
-	_, height, err := term.GetSize(int(os.Stdout.Fd()))
-	if err != nil {
-		log.Printf("failed to get window size: %v", err)
+// stdout がターミナルなら全画面ページャーで表示し、そうでなければプレーン出力する
+func printPager(tl *ecstrace.Timeline, header string) {
+	if !isTerminal(os.Stdout) {
+		printPlain(os.Stdout, tl, header)
 		return
 	}
-	tl.pageSize = height - 5 // ヘッダーとページ情報用に5行引く
-
-	currentPage := 0
-	totalPages := (len(tl.events) + tl.pageSize - 1) / tl.pageSize
-
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		// ページ描画
-		events := tl.getPageEvents(currentPage)
-		tl.renderPage(events, currentPage, totalPages)
-
-		if !scanner.Scan() {
-			// Ctrl+D or Ctrl+C
-			return
-		}
-		input := scanner.Text()
-
-		if input == "" {
-			// Enterキーだけ押された場合 → 次のページへ
-			if currentPage < totalPages-1 {
-				currentPage++
-			}
-			continue
-		} else if input == "q" {
-			// q が入力された場合 → 終了
-			return
-		}
+
+	p := tea.NewProgram(newPagerModel(sortedDescending(tl.Events()), tl.Filtered(), header), tea.WithAltScreen())
+	if _, err := p.Run(); err != nil {
+		log.Printf("failed to run pager: %v", err)
+		printPlain(os.Stdout, tl, header)
 	}
 }
 
@@ -142,12 +117,3 @@ func min(a, b int) int {
 	}
 	return b
 }
-
-// TimelineEvent を作る簡易ヘルパー
-func newEvent(ts time.Time, source, msg string) TimelineEvent {
-	return TimelineEvent{
-		Timestamp: ts,
-		Source:    source,
-		Message:   msg,
-	}
-}
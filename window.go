@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"logs-ecstask/ecstrace"
+)
+
+// RFC3339 の絶対時刻、または "15m" のような現在からの相対時間を解釈
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			d = -d
+		}
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC3339 (e.g. 2024-01-02T15:04:05Z) or a duration (e.g. 15m)", s)
+}
+
+// -since / -until / -around / -window から対象期間を組み立てる
+func buildTimeWindow(since, until, around string, window time.Duration, now time.Time) (ecstrace.TimeWindow, error) {
+	var w ecstrace.TimeWindow
+
+	if around != "" {
+		if since != "" || until != "" {
+			return w, fmt.Errorf("-around cannot be combined with -since or -until")
+		}
+		if window <= 0 {
+			return w, fmt.Errorf("-window must be positive")
+		}
+		center, err := parseTimeArg(around, now)
+		if err != nil {
+			return w, err
+		}
+		w.Start = center.Add(-window)
+		w.End = center.Add(window)
+		return w, nil
+	}
+
+	if since != "" {
+		t, err := parseTimeArg(since, now)
+		if err != nil {
+			return w, err
+		}
+		w.Start = t
+	}
+	if until != "" {
+		t, err := parseTimeArg(until, now)
+		if err != nil {
+			return w, err
+		}
+		w.End = t
+	}
+	if !w.Start.IsZero() && !w.End.IsZero() && w.End.Before(w.Start) {
+		return w, fmt.Errorf("-until must be after -since")
+	}
+	return w, nil
+}